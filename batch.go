@@ -0,0 +1,129 @@
+package kiva
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+const defaultCommitChunkSize = 500
+
+// CommitItem is one item handed to BatchCommitFunc, ID is key without table name
+type CommitItem struct {
+	Key   string
+	ID    string
+	Value interface{}
+	Op    CommitKind
+}
+
+// BatchCommitFunc commits items of one table at once. It returns error of each item at the same index,
+// nil slice means all items are committed
+type BatchCommitFunc func(ctx context.Context, tableName string, items []CommitItem) []error
+
+type pendingCommit struct {
+	item CommitItem
+	opts *ItemOptions
+}
+
+// commitBatch collects items waiting to be committed by BatchCommitter, grouped by table
+type commitBatch map[string][]pendingCommit
+
+func (b commitBatch) add(key string, value interface{}, op CommitKind, opts *ItemOptions) {
+	tableName, id, _ := ParseKey(key)
+	b[tableName] = append(b[tableName], pendingCommit{
+		item: CommitItem{Key: key, ID: id, Value: value, Op: op},
+		opts: opts,
+	})
+}
+
+func (k *Kiva) hasCommitter() bool {
+	return k.commiter != nil || k.opts.BatchCommitter != nil
+}
+
+// commitOne commits single item using committer, or BatchCommitter when committer is not set
+func (k *Kiva) commitOne(ctx context.Context, key string, value interface{}, op CommitKind) error {
+	if k.commiter != nil {
+		return k.commiter(ctx, key, value, op)
+	}
+	if k.opts.BatchCommitter == nil {
+		return fmt.Errorf("kv committer: invalid committer")
+	}
+	tableName, id, _ := ParseKey(key)
+	items := []CommitItem{{Key: key, ID: id, Value: value, Op: op}}
+	return batchErrors(k.opts.BatchCommitter(ctx, tableName, items), len(items))[0]
+}
+
+// commitTasks splits collected items into tasks handing them to BatchCommitter in chunks of CommitChunkSize,
+// failed items stay waiting for their retry as with single commit
+func (k *Kiva) commitTasks(batch commitBatch, report *syncReport) []syncTask {
+	chunkSize := k.SyncOptions().CommitChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultCommitChunkSize
+	}
+
+	tableNames := make([]string, 0, len(batch))
+	for tableName := range batch {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	tasks := []syncTask{}
+	for _, tableName := range tableNames {
+		pendings := batch[tableName]
+		for start := 0; start < len(pendings); start += chunkSize {
+			end := start + chunkSize
+			if end > len(pendings) {
+				end = len(pendings)
+			}
+			tableName, chunk := tableName, pendings[start:end]
+			tasks = append(tasks, syncTask{tableName: tableName, run: func(ctx context.Context) {
+				k.commitChunk(ctx, tableName, chunk, report)
+			}})
+		}
+	}
+	return tasks
+}
+
+func (k *Kiva) commitChunk(ctx context.Context, tableName string, chunk []pendingCommit, report *syncReport) {
+	items := make([]CommitItem, len(chunk))
+	for i, p := range chunk {
+		items[i] = p.item
+	}
+
+	errs := batchErrors(k.opts.BatchCommitter(ctx, tableName, items), len(items))
+	for i, p := range chunk {
+		k.commitResult(p, errs[i])
+		if errs[i] != nil {
+			report.add(p.item.Key, syncFailed)
+			continue
+		}
+		report.add(p.item.Key, syncCommitted)
+	}
+}
+
+// commitResult records result of a batched commit, keys are not locked while BatchCommitter runs
+// so item written meanwhile is left dirty
+func (k *Kiva) commitResult(p pendingCommit, err error) {
+	unlock := k.keyLocks.lock(p.item.Key)
+	defer unlock()
+	if err != nil {
+		k.commitFailed(p.item.Key, p.item.Value, p.item.Op, p.opts, err)
+		return
+	}
+	k.committed(p.item.Key, p.item.Op, p.opts)
+}
+
+// batchErrors normalizes result of BatchCommitFunc to one error per item
+func batchErrors(errs []error, count int) []error {
+	if errs == nil {
+		return make([]error, count)
+	}
+	if len(errs) != count {
+		e := fmt.Errorf("batch committer returns %d results for %d items", len(errs), count)
+		errs = make([]error, count)
+		for i := range errs {
+			errs[i] = e
+		}
+	}
+	return errs
+}
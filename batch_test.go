@@ -0,0 +1,115 @@
+package kiva_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatchCommit(t *testing.T) {
+	convey.Convey("batch committer", t, func() {
+		mtx := new(sync.Mutex)
+		chunks := map[string][]int{}
+		committer := func(ctx context.Context, tableName string, items []kiva.CommitItem) []error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			chunks[tableName] = append(chunks[tableName], len(items))
+			errs := make([]error, len(items))
+			for i, item := range items {
+				if item.ID == "3" {
+					errs[i] = errors.New("invalid row")
+				}
+			}
+			return errs
+		}
+
+		provider := kvsimple.New()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		kv, e := kiva.NewCtx(ctx, provider, nil, nil, nil, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+			SyncBatch: kiva.SyncBatchOptions{
+				EveryInSecond:   1,
+				CommitChunkSize: 2,
+				RetryBackoff:    time.Minute,
+			},
+			BatchCommitter: committer,
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		for i := 1; i <= 5; i++ {
+			kv.Set(fmt.Sprintf("ba:%d", i), i, nil, true)
+		}
+		kv.Set("bb:1", 1, nil, true)
+		time.Sleep(1500 * time.Millisecond)
+
+		mtx.Lock()
+		convey.So(chunks["ba"], convey.ShouldResemble, []int{2, 2, 1})
+		convey.So(chunks["bb"], convey.ShouldResemble, []int{1})
+		mtx.Unlock()
+
+		convey.So(provider.ItemOpts("ba:1").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+		convey.So(provider.ItemOpts("bb:1").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+		failed := provider.ItemOpts("ba:3")
+		convey.So(failed.SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+		convey.So(failed.CommitAttempts, convey.ShouldEqual, 1)
+		convey.So(failed.LastCommitError, convey.ShouldEqual, "invalid row")
+
+		convey.Convey("single commit uses batch committer when committer is not set", func() {
+			e := kv.Set("bc:3", 3, &kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNow}, true)
+			convey.So(e, convey.ShouldNotBeNil)
+			convey.So(kv.Set("bc:4", 4, &kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNow}, true), convey.ShouldBeNil)
+			convey.So(provider.ItemOpts("bc:4").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+		})
+	})
+	convey.Convey("write arriving while batch is committed", t, func() {
+		started, release := make(chan struct{}, 1), make(chan struct{})
+		mtx := new(sync.Mutex)
+		db := map[string]interface{}{}
+		committer := func(ctx context.Context, tableName string, items []kiva.CommitItem) []error {
+			select {
+			case started <- struct{}{}:
+				<-release
+			default:
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			for _, item := range items {
+				db[item.Key] = item.Value
+			}
+			return nil
+		}
+		provider := kvsimple.New()
+		kv, e := kiva.NewCtx(context.Background(), provider, nil, nil, nil, &kiva.KivaOptions{
+			DefaultWrite:   kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+			BatchCommitter: committer,
+		})
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(kv.Set("br:A", 1, nil, true), convey.ShouldBeNil)
+
+		synced := make(chan struct{})
+		go func() {
+			kv.SyncKeys(context.Background(), "br:A")
+			close(synced)
+		}()
+		<-started
+		convey.So(kv.Set("br:A", 2, nil, true), convey.ShouldBeNil)
+		close(release)
+		<-synced
+
+		convey.So(provider.ItemOpts("br:A").SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+		_, e = kv.SyncKeys(context.Background(), "br:A")
+		convey.So(e, convey.ShouldBeNil)
+		mtx.Lock()
+		convey.So(db["br:A"], convey.ShouldEqual, 2)
+		mtx.Unlock()
+		convey.So(provider.ItemOpts("br:A").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+	})
+}
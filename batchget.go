@@ -0,0 +1,204 @@
+package kiva
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/ariefdarmawan/serde"
+)
+
+const defaultRefreshChunkSize = 500
+
+// BatchGetterFunc reads items of one table at once, it returns found items keyed by id.
+// Id not found on persistent storage is left out of the result
+type BatchGetterFunc func(ctx context.Context, tableName string, ids []string) (map[string]interface{}, error)
+
+func (k *Kiva) GetMany(keys []string, dest interface{}) error {
+	return k.GetManyCtx(k.ctx, keys, dest)
+}
+
+// GetManyCtx reads keys into dest which should be pointer of map[string]T keyed by key. Items present on provider
+// are taken from it, the rest are loaded by BatchGetter in one call per table (or by getter per key when BatchGetter
+// is not set). Keys not found anywhere are left out of dest
+func (k *Kiva) GetManyCtx(ctx context.Context, keys []string, dest interface{}) error {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Map || rv.Elem().Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("output should be ptr of map[string]T")
+	}
+	mapValue := rv.Elem()
+	if mapValue.IsNil() {
+		mapValue.Set(reflect.MakeMap(mapValue.Type()))
+	}
+	rtElem := mapValue.Type().Elem()
+
+	missing := []string{}
+	now := time.Now()
+	for _, key := range keys {
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		newElem := reflect.New(rtElem)
+		opts, e := ProviderGet(ctx, k.provider, key, newElem.Interface())
+		if e == nil && (opts.Expiry.After(now) || opts.Kind == ItemTombstone) {
+			if !opts.Hidden() {
+				mapValue.SetMapIndex(reflect.ValueOf(key).Convert(mapValue.Type().Key()), newElem.Elem())
+			}
+			continue
+		}
+		missing = append(missing, key)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	found, e := k.loadMany(ctx, missing, rtElem)
+	for key, v := range found {
+		mapValue.SetMapIndex(reflect.ValueOf(key).Convert(mapValue.Type().Key()), v)
+	}
+	return e
+}
+
+// loadMany loads keys thru BatchGetter, or thru getter per key when BatchGetter is not set,
+// loaded items are written to provider and missing ones are marked as missing. Key written while
+// BatchGetter runs keeps its value, which is returned instead
+func (k *Kiva) loadMany(ctx context.Context, keys []string, rtElem reflect.Type) (map[string]reflect.Value, error) {
+	found := map[string]reflect.Value{}
+	if k.opts.BatchGetter == nil {
+		if k.getter == nil {
+			return found, nil
+		}
+		for _, key := range keys {
+			newElem := reflect.New(rtElem)
+			if _, e := k.load(ctx, key, newElem.Interface()); e != nil {
+				if e == ErrNotFound {
+					continue
+				}
+				return found, e
+			}
+			found[key] = newElem.Elem()
+		}
+		return found, nil
+	}
+
+	for _, group := range groupByTable(keys) {
+		atomic.AddInt64(&k.stats.batchGetterCalls, 1)
+		values, e := k.opts.BatchGetter(ctx, group.tableName, group.ids)
+		if e != nil {
+			return found, fmt.Errorf("kv batch getter: %s", e.Error())
+		}
+		for i, id := range group.ids {
+			key := group.keys[i]
+			newElem := reflect.New(rtElem)
+			value, ok := values[id]
+			if !ok {
+				k.markMissing(ctx, key, newElem.Interface())
+				continue
+			}
+			if e = serde.Serde(value, newElem.Interface()); e != nil {
+				return found, fmt.Errorf("kv batch getter: key %s. %s", key, e.Error())
+			}
+			if _, e = k.storeLoaded(ctx, key, newElem.Interface()); e != nil {
+				if e == ErrNotFound {
+					continue
+				}
+				return found, e
+			}
+			found[key] = newElem.Elem()
+		}
+	}
+	return found, nil
+}
+
+// refreshTasks splits hot items into tasks reloading them thru BatchGetter in chunks of RefreshChunkSize,
+// items no longer exist on persistent storage are removed
+func (k *Kiva) refreshTasks(keys []string, report *syncReport) []syncTask {
+	chunkSize := k.SyncOptions().RefreshChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultRefreshChunkSize
+	}
+	tasks := []syncTask{}
+	for _, group := range groupByTable(keys) {
+		for start := 0; start < len(group.ids); start += chunkSize {
+			end := start + chunkSize
+			if end > len(group.ids) {
+				end = len(group.ids)
+			}
+			tableName, keys, ids := group.tableName, group.keys[start:end], group.ids[start:end]
+			tasks = append(tasks, syncTask{tableName: tableName, run: func(ctx context.Context) {
+				k.refreshChunk(ctx, tableName, keys, ids, report)
+			}})
+		}
+	}
+	return tasks
+}
+
+func (k *Kiva) refreshChunk(ctx context.Context, tableName string, keys, ids []string, report *syncReport) {
+	atomic.AddInt64(&k.stats.batchGetterCalls, 1)
+	values, e := k.opts.BatchGetter(ctx, tableName, ids)
+	if e != nil {
+		for _, key := range keys {
+			report.add(key, syncFailed)
+		}
+		return
+	}
+	for i, key := range keys {
+		value, ok := values[ids[i]]
+		if outcome, refreshed := k.refreshKey(ctx, key, value, ok); refreshed {
+			report.add(key, outcome)
+		}
+	}
+}
+
+// refreshKey replaces hot item by its value read from persistent storage, or removes it when it is not found.
+// Keys are not locked while BatchGetter runs, item written meanwhile is dirty and keeps its value
+func (k *Kiva) refreshKey(ctx context.Context, key string, value interface{}, found bool) (syncOutcome, bool) {
+	unlock := k.keyLocks.lock(key)
+	defer unlock()
+	if opts := k.provider.ItemOpts(key); opts == nil || opts.SyncDirection != SyncToHots {
+		return 0, false
+	}
+	if !found {
+		ProviderDelete(ctx, k.provider, key)
+		return syncEvicted, true
+	}
+	k.set(ctx, key, value, k.writeOptions(key), false)
+	k.provider.UpdateLastSyncTime(key)
+	return syncRefreshed, true
+}
+
+type tableKeys struct {
+	tableName string
+	keys      []string
+	ids       []string
+}
+
+// groupByTable splits keys by table, ids of each table are in the same order as keys
+func groupByTable(keys []string) []*tableKeys {
+	groups := map[string]*tableKeys{}
+	for _, key := range keys {
+		tableName, id, e := ParseKey(key)
+		if e != nil {
+			continue
+		}
+		g, ok := groups[tableName]
+		if !ok {
+			g = &tableKeys{tableName: tableName}
+			groups[tableName] = g
+		}
+		g.keys = append(g.keys, key)
+		g.ids = append(g.ids, id)
+	}
+
+	res := make([]*tableKeys, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].tableName < res[j].tableName
+	})
+	return res
+}
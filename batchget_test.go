@@ -0,0 +1,183 @@
+package kiva_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatchGetter(t *testing.T) {
+	convey.Convey("batch getter", t, func() {
+		mtx := new(sync.Mutex)
+		db := map[string]int{"1": 10, "2": 20, "3": 30}
+		requested := [][]string{}
+		batchGetter := func(ctx context.Context, tableName string, ids []string) (map[string]interface{}, error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			requested = append(requested, ids)
+			res := map[string]interface{}{}
+			for _, id := range ids {
+				if v, ok := db[id]; ok {
+					res[id] = v
+				}
+			}
+			return res, nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		kv, e := kiva.NewCtx(ctx, kvsimple.New(), func(string) interface{} { return 0 }, nil, nil, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+			NegativeTTL:  time.Minute,
+			BatchGetter:  batchGetter,
+		})
+		convey.So(e, convey.ShouldBeNil)
+		kv.Set("bg:1", 11, nil, false)
+
+		convey.Convey("GetMany reads present items and loads the rest at once", func() {
+			items := map[string]int{}
+			convey.So(kv.GetMany([]string{"bg:1", "bg:2", "bg:3", "bg:4"}, &items), convey.ShouldBeNil)
+			convey.So(items, convey.ShouldResemble, map[string]int{"bg:1": 11, "bg:2": 20, "bg:3": 30})
+			convey.So(requested, convey.ShouldResemble, [][]string{{"2", "3", "4"}})
+
+			convey.Convey("loaded and missing keys are cached", func() {
+				items = map[string]int{}
+				convey.So(kv.GetMany([]string{"bg:2", "bg:4"}, &items), convey.ShouldBeNil)
+				convey.So(items, convey.ShouldResemble, map[string]int{"bg:2": 20})
+				convey.So(kv.Stats().BatchGetterCalls, convey.ShouldEqual, 1)
+			})
+		})
+
+		convey.Convey("table GetMany is keyed by id", func() {
+			items, e := kiva.NewTable[int](kv, "bg").GetMany("1", "3")
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(items, convey.ShouldResemble, map[string]int{"1": 11, "3": 30})
+		})
+	})
+	convey.Convey("write arriving while GetMany loads is kept", t, func() {
+		started, release := make(chan struct{}), make(chan struct{})
+		batchGetter := func(ctx context.Context, tableName string, ids []string) (map[string]interface{}, error) {
+			close(started)
+			<-release
+			return map[string]interface{}{"1": 100}, nil
+		}
+		provider := kvsimple.New()
+		kv, e := kiva.NewCtx(context.Background(), provider, func(string) interface{} { return 0 }, nil, nil, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+			NegativeTTL:  time.Minute,
+			BatchGetter:  batchGetter,
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		items := map[string]int{}
+		loaded := make(chan error)
+		go func() {
+			loaded <- kv.GetMany([]string{"bl:1", "bl:2"}, &items)
+		}()
+		<-started
+		convey.So(kv.Set("bl:1", 11, nil, true), convey.ShouldBeNil)
+		convey.So(kv.Set("bl:2", 22, nil, true), convey.ShouldBeNil)
+		close(release)
+		convey.So(<-loaded, convey.ShouldBeNil)
+
+		convey.So(items["bl:1"], convey.ShouldEqual, 11)
+		v := 0
+		convey.So(kv.Get("bl:1", &v), convey.ShouldBeNil)
+		convey.So(v, convey.ShouldEqual, 11)
+		convey.So(kv.Get("bl:2", &v), convey.ShouldBeNil)
+		convey.So(v, convey.ShouldEqual, 22)
+		convey.So(provider.ItemOpts("bl:1").SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+	})
+}
+
+func TestBatchRefresh(t *testing.T) {
+	convey.Convey("sync refreshes hot items thru batch getter", t, func() {
+		mtx := new(sync.Mutex)
+		db := map[string]int{"1": 100, "2": 200}
+		calls := 0
+		batchGetter := func(ctx context.Context, tableName string, ids []string) (map[string]interface{}, error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			calls++
+			res := map[string]interface{}{}
+			for _, id := range ids {
+				if v, ok := db[id]; ok {
+					res[id] = v
+				}
+			}
+			return res, nil
+		}
+
+		provider := kvsimple.New()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		kv, e := kiva.NewCtx(ctx, provider, func(string) interface{} { return 0 }, nil, nil, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+			SyncBatch:    kiva.SyncBatchOptions{EveryInSecond: 1},
+			BatchGetter:  batchGetter,
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		items := map[string]int{}
+		convey.So(kv.GetMany([]string{"br:1", "br:2"}, &items), convey.ShouldBeNil)
+		mtx.Lock()
+		db["1"] = 101
+		delete(db, "2")
+		mtx.Unlock()
+
+		time.Sleep(1500 * time.Millisecond)
+		v := 0
+		convey.So(kv.Get("br:1", &v), convey.ShouldBeNil)
+		convey.So(v, convey.ShouldEqual, 101)
+		convey.So(provider.HasKey("br:2"), convey.ShouldBeFalse)
+		mtx.Lock()
+		convey.So(calls, convey.ShouldEqual, 2)
+		mtx.Unlock()
+	})
+	convey.Convey("write arriving while batch getter runs is kept", t, func() {
+		started, release := make(chan struct{}, 1), make(chan struct{})
+		batchGetter := func(ctx context.Context, tableName string, ids []string) (map[string]interface{}, error) {
+			select {
+			case started <- struct{}{}:
+				<-release
+			default:
+			}
+			return map[string]interface{}{"1": 100}, nil
+		}
+		provider := kvsimple.New()
+		kv, e := kiva.NewCtx(context.Background(), provider, func(string) interface{} { return 0 }, nil, nil, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+			BatchGetter:  batchGetter,
+		})
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(kv.Set("bw:1", 1, nil, false), convey.ShouldBeNil)
+		convey.So(kv.Set("bw:2", 2, nil, false), convey.ShouldBeNil)
+		provider.UpdateLastSyncTime("bw:1")
+		provider.UpdateLastSyncTime("bw:2")
+
+		synced := make(chan kiva.SyncReport)
+		go func() {
+			report, _ := kv.SyncKeys(context.Background(), "bw:1", "bw:2")
+			synced <- report
+		}()
+		<-started
+		convey.So(kv.Set("bw:1", 11, nil, true), convey.ShouldBeNil)
+		convey.So(kv.Set("bw:2", 22, nil, true), convey.ShouldBeNil)
+		close(release)
+		report := <-synced
+
+		convey.So(report.Refreshed, convey.ShouldBeEmpty)
+		convey.So(report.Evicted, convey.ShouldBeEmpty)
+		v := 0
+		convey.So(kv.Get("bw:1", &v), convey.ShouldBeNil)
+		convey.So(v, convey.ShouldEqual, 11)
+		convey.So(kv.Get("bw:2", &v), convey.ShouldBeNil)
+		convey.So(v, convey.ShouldEqual, 22)
+		convey.So(provider.ItemOpts("bw:1").SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+	})
+}
@@ -0,0 +1,60 @@
+package kiva
+
+import (
+	"context"
+	"fmt"
+)
+
+// UnflushedError is returned by Close when some items could not be committed before Kiva is closed.
+// Items moved to dead letter store are listed too, they are not replayed by Close
+type UnflushedError struct {
+	Keys []string
+}
+
+func (e *UnflushedError) Error() string {
+	return fmt.Sprintf("%d items are not flushed to persistent storage", len(e.Keys))
+}
+
+// Close stops background sync, commits all items waiting to be committed and closes provider. Flush stops
+// when ctx is done, items left uncommitted are listed by returned *UnflushedError. Kiva should not be used after Close
+func (k *Kiva) Close(ctx context.Context) error {
+	var err error
+	k.closeOnce.Do(func() {
+		close(k.stop)
+		select {
+		case <-k.loopDone:
+		case <-ctx.Done():
+		}
+		k.coalescer.drain()
+
+		if unflushed := k.flush(ctx); len(unflushed) > 0 {
+			err = &UnflushedError{Keys: unflushed}
+		}
+		k.provider.Close()
+	})
+	return err
+}
+
+// flush commits all dirty items of provider and returns keys still dirty or dead lettered afterward
+func (k *Kiva) flush(ctx context.Context) []string {
+	dirtyKeys := k.dirtyKeys(ctx)
+	if len(dirtyKeys) == 0 {
+		return nil
+	}
+	if k.hasCommitter() && ctx.Err() == nil {
+		k.syncKeys(ctx, dirtyKeys, true)
+		dirtyKeys = k.dirtyKeys(context.Background())
+	}
+	return dirtyKeys
+}
+
+func (k *Kiva) dirtyKeys(ctx context.Context) []string {
+	keys := []string{}
+	for _, key := range ProviderKeys(ctx, k.provider, "*") {
+		opts := k.provider.ItemOpts(key)
+		if opts != nil && (opts.Pending() || opts.SyncDirection == SyncDeadLetter) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
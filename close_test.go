@@ -0,0 +1,83 @@
+package kiva_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestClose(t *testing.T) {
+	convey.Convey("close kiva with dirty items", t, func() {
+		mtx := new(sync.Mutex)
+		commits := map[string]interface{}{}
+		failKey := ""
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if key == failKey {
+				return errors.New("db is down")
+			}
+			commits[key] = value
+			return nil
+		}
+
+		provider := kvsimple.New()
+		kv, e := kiva.NewCtx(context.Background(), provider, nil, nil, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+			SyncBatch:    kiva.SyncBatchOptions{EveryInSecond: 60},
+			Coalesce:     kiva.CoalesceOptions{Window: time.Minute},
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		convey.So(kv.Set("cl:A", 1, nil, true), convey.ShouldBeNil)
+		convey.So(kv.Set("cl:B", 2, nil, true), convey.ShouldBeNil)
+		convey.So(kv.Set("cl:C", 3, &kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNow}, true), convey.ShouldBeNil)
+
+		convey.Convey("pending writes are flushed", func() {
+			convey.So(kv.Close(context.Background()), convey.ShouldBeNil)
+			convey.So(commits, convey.ShouldResemble, map[string]interface{}{"cl:A": 1, "cl:B": 2, "cl:C": 3})
+			convey.So(kv.Close(context.Background()), convey.ShouldBeNil)
+		})
+
+		convey.Convey("failed flush reports unflushed keys", func() {
+			failKey = "cl:B"
+			e := kv.Close(context.Background())
+			unflushed := new(kiva.UnflushedError)
+			convey.So(errors.As(e, &unflushed), convey.ShouldBeTrue)
+			convey.So(unflushed.Keys, convey.ShouldResemble, []string{"cl:B"})
+		})
+
+		convey.Convey("expired deadline leaves items unflushed", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			e := kv.Close(ctx)
+			unflushed := new(kiva.UnflushedError)
+			convey.So(errors.As(e, &unflushed), convey.ShouldBeTrue)
+			sort.Strings(unflushed.Keys)
+			convey.So(unflushed.Keys, convey.ShouldResemble, []string{"cl:A", "cl:B", "cl:C"})
+		})
+	})
+	convey.Convey("close kiva with dead lettered items", t, func() {
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			return errors.New("db is down")
+		}
+		kv, e := kiva.NewCtx(context.Background(), kvsimple.New(), nil, nil, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNow},
+			SyncBatch:    kiva.SyncBatchOptions{MaxAttempts: 1},
+		})
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(kv.Set("cd:A", 1, nil, true), convey.ShouldNotBeNil)
+
+		e = kv.Close(context.Background())
+		unflushed := new(kiva.UnflushedError)
+		convey.So(errors.As(e, &unflushed), convey.ShouldBeTrue)
+		convey.So(unflushed.Keys, convey.ShouldResemble, []string{"cd:A"})
+	})
+}
@@ -0,0 +1,115 @@
+package kiva
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type coalescedWrite struct {
+	due      time.Time
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// coalescer delays commit of SyncNow writes, writes of the same key arriving within Window of the previous one
+// are merged and only the latest value is committed. A key is committed at most MaxDelay after its first merged write
+type coalescer struct {
+	mtx      sync.Mutex
+	window   time.Duration
+	maxDelay time.Duration
+	writes   map[string]*coalescedWrite
+}
+
+func newCoalescer(opts CoalesceOptions) *coalescer {
+	c := &coalescer{
+		window:   opts.Window,
+		maxDelay: opts.MaxDelay,
+		writes:   make(map[string]*coalescedWrite),
+	}
+	if c.maxDelay <= 0 {
+		c.maxDelay = 10 * c.window
+	}
+	return c
+}
+
+func (c *coalescer) enabled() bool {
+	return c.window > 0
+}
+
+func (c *coalescer) pending(key string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	_, ok := c.writes[key]
+	return ok
+}
+
+// drain stops all timers and forgets pending writes, their latest values are on provider waiting to be committed
+func (c *coalescer) drain() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for key, w := range c.writes {
+		w.timer.Stop()
+		delete(c.writes, key)
+	}
+}
+
+// coalesce registers write of key to be committed, commit of pending write of the key is postponed
+func (k *Kiva) coalesce(key string) {
+	c := k.coalescer
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	if w, ok := c.writes[key]; ok {
+		atomic.AddInt64(&k.stats.writesCoalesced, 1)
+		w.due = now.Add(c.window)
+		if w.due.After(w.deadline) {
+			w.due = w.deadline
+		}
+		return
+	}
+
+	w := &coalescedWrite{
+		due:      now.Add(c.window),
+		deadline: now.Add(c.maxDelay),
+	}
+	c.writes[key] = w
+	w.timer = time.AfterFunc(c.window, func() {
+		k.commitCoalesced(key, w)
+	})
+}
+
+// commitCoalesced commits the latest value of key once it is due, timer is rearmed when newer write has postponed it.
+// Value is read from provider under lock of key, so it is the one marked as synced
+func (k *Kiva) commitCoalesced(key string, w *coalescedWrite) {
+	c := k.coalescer
+	c.mtx.Lock()
+	if c.writes[key] != w {
+		c.mtx.Unlock()
+		return
+	}
+	if wait := time.Until(w.due); wait > 0 {
+		w.timer.Reset(wait)
+		c.mtx.Unlock()
+		return
+	}
+	delete(c.writes, key)
+	c.mtx.Unlock()
+
+	unlock := k.keyLocks.lock(key)
+	defer unlock()
+	tableName, _, _ := ParseKey(key)
+	item := k.newItem(tableName)
+	opts, e := ProviderGet(k.ctx, k.provider, key, item)
+	if e != nil || opts.SyncDirection != SyncToPersistent || opts.Hidden() {
+		return
+	}
+	if k.SyncPaused() {
+		// item stays dirty on provider, sync commits it once resumed
+		k.markDirty(key)
+		return
+	}
+	k.commit(k.ctx, key, reflect.Indirect(reflect.ValueOf(item)).Interface(), CommitSave, opts)
+}
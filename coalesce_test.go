@@ -0,0 +1,69 @@
+package kiva_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestCoalesce(t *testing.T) {
+	convey.Convey("rapid writes of the same key", t, func() {
+		mtx := new(sync.Mutex)
+		commits := []interface{}{}
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			commits = append(commits, value)
+			return nil
+		}
+		committed := func() []interface{} {
+			mtx.Lock()
+			defer mtx.Unlock()
+			return append([]interface{}{}, commits...)
+		}
+
+		provider := kvsimple.New()
+		kv, e := kiva.NewCtx(context.Background(), provider, nil, nil, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNow},
+			Coalesce:     kiva.CoalesceOptions{Window: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond},
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		convey.Convey("only the latest value is committed", func() {
+			for i := 1; i <= 5; i++ {
+				convey.So(kv.Set("co:A", i, nil, true), convey.ShouldBeNil)
+			}
+			convey.So(committed(), convey.ShouldBeEmpty)
+			convey.So(provider.ItemOpts("co:A").SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+
+			time.Sleep(200 * time.Millisecond)
+			convey.So(committed(), convey.ShouldResemble, []interface{}{5})
+			convey.So(provider.ItemOpts("co:A").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+			convey.So(kv.Stats().WritesCoalesced, convey.ShouldEqual, 4)
+		})
+
+		convey.Convey("continuous writes are committed within max delay", func() {
+			start := time.Now()
+			for i := 1; time.Since(start) < 450*time.Millisecond; i++ {
+				kv.Set("co:B", i, nil, true)
+				time.Sleep(20 * time.Millisecond)
+			}
+			convey.So(len(committed()), convey.ShouldBeGreaterThanOrEqualTo, 1)
+		})
+
+		convey.Convey("value on provider at commit time is committed", func() {
+			convey.So(kv.Set("co:C", 1, nil, true), convey.ShouldBeNil)
+			// write which has reached provider but not coalescer yet
+			provider.Set("co:C", 2, &kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNow})
+
+			time.Sleep(200 * time.Millisecond)
+			convey.So(committed(), convey.ShouldResemble, []interface{}{2})
+			convey.So(provider.ItemOpts("co:C").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+		})
+	})
+}
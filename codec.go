@@ -0,0 +1,92 @@
+package kiva
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/sebarcode/kiva/msgpack"
+)
+
+// Codec converts item values to bytes and back, it is used by providers keeping items out of process
+// (kvredis, kvmemcache, kvdisk). Decode receives pointer of destination, as Get of provider does
+type Codec interface {
+	Encode(value interface{}) ([]byte, error)
+	Decode(data []byte, dest interface{}) error
+}
+
+var (
+	// JSONCodec is the default codec, value decoded into interface{} becomes map[string]interface{}
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec needs concrete destination, table should have a reflector registered to be synced
+	GobCodec Codec = gobCodec{}
+
+	// MsgpackCodec uses package msgpack, structs are encoded as maps keyed by json name of their fields.
+	// Value decoded into interface{} becomes map[string]interface{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// Codecs selects codec of an item by table of its key, Default is used for tables not listed
+// and JSONCodec when Default is nil. Items already stored are not converted when codec of a table is changed
+type Codecs struct {
+	Default Codec
+	Tables  map[string]Codec
+}
+
+// Of returns codec of given key
+func (c Codecs) Of(key string) Codec {
+	if tableName, _, e := ParseKey(key); e == nil {
+		if codec, ok := c.Tables[tableName]; ok && codec != nil {
+			return codec
+		}
+	}
+	if c.Default != nil {
+		return c.Default
+	}
+	return JSONCodec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Decode(data []byte, dest interface{}) error {
+	return json.Unmarshal(data, dest)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(value interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if e := gob.NewEncoder(buf).Encode(value); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, dest interface{}) error {
+	// interface holding pointer of item (e.g. made by reflector) is decoded into that pointer
+	if rv := reflect.ValueOf(dest); rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Interface {
+		inner := rv.Elem().Elem()
+		if !inner.IsValid() || inner.Kind() != reflect.Ptr || inner.IsNil() {
+			return errors.New("gob can not decode into interface, register reflector of the table")
+		}
+		dest = inner.Interface()
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(dest)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Encode(value interface{}) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (msgpackCodec) Decode(data []byte, dest interface{}) error {
+	return msgpack.Unmarshal(data, dest)
+}
@@ -0,0 +1,88 @@
+package kiva_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type CodecBase struct {
+	ID string `json:"_id"`
+}
+
+type codecItem struct {
+	CodecBase
+	Name    string
+	Age     int
+	Salary  float64
+	Active  bool
+	Tags    []string
+	Scores  map[string]int
+	Counts  map[int]uint16
+	Raw     []byte
+	Created time.Time
+	Parent  *codecItem
+	Skipped string `json:"-"`
+}
+
+func TestCodec(t *testing.T) {
+	source := codecItem{
+		CodecBase: CodecBase{ID: "C01"},
+		Name:      "Codec",
+		Age:       -40,
+		Salary:    1234.5,
+		Active:    true,
+		Tags:      []string{"a", "b"},
+		Scores:    map[string]int{"x": 1, "y": 70000},
+		Counts:    map[int]uint16{3: 300},
+		Raw:       []byte{0, 1, 2},
+		Created:   time.Date(2023, 5, 1, 10, 0, 0, 123456789, time.UTC),
+		Parent:    &codecItem{Name: "Parent", Age: 1 << 40},
+		Skipped:   "skipped",
+	}
+
+	convey.Convey("codecs keep value", t, func() {
+		for name, codec := range map[string]kiva.Codec{"json": kiva.JSONCodec, "gob": kiva.GobCodec, "msgpack": kiva.MsgpackCodec} {
+			bs, e := codec.Encode(source)
+			convey.So(e, convey.ShouldBeNil)
+
+			dest := codecItem{}
+			convey.So(codec.Decode(bs, &dest), convey.ShouldBeNil)
+			expected := source
+			if name != "gob" {
+				expected.Skipped = ""
+			}
+			convey.So(dest, convey.ShouldResemble, expected)
+
+			// interface holding pointer, as made by table reflector, is decoded into that pointer
+			var item interface{} = &codecItem{}
+			convey.So(codec.Decode(bs, &item), convey.ShouldBeNil)
+			convey.So(item.(*codecItem).Name, convey.ShouldEqual, "Codec")
+		}
+	})
+
+	convey.Convey("msgpack decodes into generic value", t, func() {
+		bs, e := kiva.MsgpackCodec.Encode(source)
+		convey.So(e, convey.ShouldBeNil)
+
+		var dest interface{}
+		convey.So(kiva.MsgpackCodec.Decode(bs, &dest), convey.ShouldBeNil)
+		m := dest.(map[string]interface{})
+		convey.So(m["_id"], convey.ShouldEqual, "C01")
+		convey.So(m["Age"], convey.ShouldEqual, int64(-40))
+		convey.So(m["Tags"], convey.ShouldResemble, []interface{}{"a", "b"})
+
+		small := struct{ Age uint8 }{}
+		convey.So(kiva.MsgpackCodec.Decode(bs, &small), convey.ShouldNotBeNil)
+		convey.So(kiva.MsgpackCodec.Decode(bs[:len(bs)-3], &dest), convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("codec is selected by table", t, func() {
+		codecs := kiva.Codecs{Default: kiva.GobCodec, Tables: map[string]kiva.Codec{"packed": kiva.MsgpackCodec}}
+		convey.So(codecs.Of("packed:A"), convey.ShouldHaveSameTypeAs, kiva.MsgpackCodec)
+		convey.So(codecs.Of("other:A"), convey.ShouldHaveSameTypeAs, kiva.GobCodec)
+		convey.So(kiva.Codecs{}.Of("other:A"), convey.ShouldHaveSameTypeAs, kiva.JSONCodec)
+	})
+}
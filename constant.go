@@ -13,20 +13,91 @@ const (
 )
 
 type WriteOptions struct {
-	TTL               time.Duration
+	TTL time.Duration
+	// SyncKind tells how item is committed to persistent storage, SyncNone keeps it on cache only
 	SyncKind          SyncKindEnum
 	SyncEveryInSecond int
 	ExpiryKind        ExpiryKindEnum
+	Kind              ItemKindEnum
+	// GracePeriod keeps item after it expires, within it Get tries to reload the item and
+	// returns the expired one along with ErrStale when getter fails
+	GracePeriod time.Duration
 }
 
 type SyncBatchOptions struct {
-	EveryInSecond       int
+	EveryInSecond int
+	// SyncTimeoutInSecond is deadline of every sync operation, i.e. a commit, a refresh or a batch call
 	SyncTimeoutInSecond int
+
+	// Workers is number of goroutines running sync operations of a pass in parallel, default is 1.
+	// A key is never synced by 2 workers at the same time
+	Workers int
+	// MaxPerTable bounds number of sync operations of one table running at a time, zero means bounded by Workers only.
+	// TableConcurrency overrides it for given tables
+	MaxPerTable      int
+	TableConcurrency map[string]int
+
+	// MaxAttempts is number of failed commits after which item is moved to dead letter store, zero means retry forever
+	MaxAttempts int
+	// RetryBackoff is delay after first failed commit, it doubles on every next failure up to MaxRetryBackoff.
+	// Default is 1 second and 5 minutes
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+
+	// CommitChunkSize is max number of items handed to BatchCommitter at once, default is 500
+	CommitChunkSize int
+	// RefreshChunkSize is max number of ids handed to BatchGetter at once on sync, default is 500
+	RefreshChunkSize int
+
+	// RescanEveryInSecond is interval of full keyspace scan, needed when other process writes to the same provider.
+	// Zero means keyspace is scanned only on first sync pass
+	RescanEveryInSecond int
+}
+
+// RefreshOptions controls background reload of items thru getter
+type RefreshOptions struct {
+	// StaleWhileRevalidate makes Get return expired item as is while it is reloaded on background,
+	// item which has not been synced to persistent storage yet is never reloaded
+	StaleWhileRevalidate bool
+	// StaleFor is how long an expired item may still be served by StaleWhileRevalidate, default is TTL of the item.
+	// Items are written with GracePeriod of at least StaleFor, so providers with native TTL (kvredis, kvmemcache) keep them that long
+	StaleFor time.Duration
+	// AheadRatio is fraction of TTL, e.g. 0.8, after which an item read by Get is reloaded on background
+	// before it expires. Zero disables refresh-ahead
+	AheadRatio float64
+	// MaxConcurrent bounds number of background reloads running at a time, reload requested while
+	// all slots are busy is skipped. Default is 4
+	MaxConcurrent int
+}
+
+// CoalesceOptions controls merging of rapid SyncNow writes of the same key
+type CoalesceOptions struct {
+	// Window is how long commit of a write waits for a newer write of the same key, zero disables coalescing
+	Window time.Duration
+	// MaxDelay is the longest a write may wait to be committed while newer writes keep coming, default is 10 times Window
+	MaxDelay time.Duration
 }
 
 type KivaOptions struct {
 	DefaultWrite WriteOptions
 	SyncBatch    SyncBatchOptions
+	Refresh      RefreshOptions
+	Coalesce     CoalesceOptions
+
+	// NegativeTTL is how long a key not found by getter is remembered as missing,
+	// reads of that key return ErrNotFound without calling getter. Zero disables it
+	NegativeTTL time.Duration
+
+	// DeadLetter keeps commits which fail MaxAttempts times, default is NewMemoryDeadLetter
+	DeadLetter DeadLetterStore
+
+	// BatchCommitter, when set, is used by Sync to commit dirty items of a table together instead of
+	// calling committer per item. It is also used for single item commits when committer is not set
+	BatchCommitter BatchCommitFunc
+
+	// BatchGetter, when set, loads missing keys of GetMany, GetByPattern and GetRange together and
+	// is used by Sync to refresh hot items of a table in one call instead of calling getter per item
+	BatchGetter BatchGetterFunc
 }
 
 type GetKind string
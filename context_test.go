@@ -0,0 +1,69 @@
+package kiva_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type ctxKey string
+
+func TestContext(t *testing.T) {
+	convey.Convey("context aware kiva", t, func() {
+		committed := map[string]interface{}{}
+		getter := func(ctx context.Context, key1, key2 string, op kiva.GetKind, dest interface{}) error {
+			if e := ctx.Err(); e != nil {
+				return e
+			}
+			if ctx.Value(ctxKey("user")) != "admin" {
+				return errors.New("missing request context")
+			}
+			*(dest.(*int)) = 10
+			return nil
+		}
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			if e := ctx.Err(); e != nil {
+				return e
+			}
+			committed[key] = value
+			return nil
+		}
+
+		kv, e := kiva.NewCtx(context.Background(), kvsimple.New(), nil, getter, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNow},
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		reqCtx := context.WithValue(context.Background(), ctxKey("user"), "admin")
+		convey.Convey("getter receives request context", func() {
+			v := 0
+			convey.So(kv.GetCtx(reqCtx, "ctx:A", &v), convey.ShouldBeNil)
+			convey.So(v, convey.ShouldEqual, 10)
+
+			convey.Convey("cached item does not call getter", func() {
+				v = 0
+				convey.So(kv.Get("ctx:A", &v), convey.ShouldBeNil)
+				convey.So(v, convey.ShouldEqual, 10)
+			})
+		})
+
+		convey.Convey("cancelled context reaches getter and committer", func() {
+			cancelled, cancel := context.WithCancel(reqCtx)
+			cancel()
+
+			v := 0
+			convey.So(kv.GetCtx(cancelled, "ctx:B", &v), convey.ShouldNotBeNil)
+			convey.So(kv.Keys("ctx:B"), convey.ShouldBeEmpty)
+
+			convey.So(kv.SetCtx(cancelled, "ctx:C", 5, nil, true), convey.ShouldNotBeNil)
+			convey.So(committed["ctx:C"], convey.ShouldBeNil)
+			convey.So(kv.SetCtx(reqCtx, "ctx:C", 5, nil, true), convey.ShouldBeNil)
+			convey.So(committed["ctx:C"], convey.ShouldEqual, 5)
+		})
+	})
+}
@@ -0,0 +1,115 @@
+package kiva
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+type fileDeadLetter struct {
+	mtx     sync.RWMutex
+	path    string
+	commits map[string]FailedCommit
+}
+
+// NewFileDeadLetter returns DeadLetterStore kept as JSON file on given path, entries written by previous
+// process are loaded so failed commits survive restart. File is rewritten on every change, it is meant for
+// a handful of failed commits rather than a queue. Values are read back as decoded by JSON, replay commits
+// value of item on provider instead when the item is still there
+func NewFileDeadLetter(path string) (DeadLetterStore, error) {
+	s := &fileDeadLetter{path: path, commits: make(map[string]FailedCommit)}
+	bs, e := os.ReadFile(path)
+	if os.IsNotExist(e) {
+		return s, nil
+	}
+	if e != nil {
+		return nil, fmt.Errorf("dead letter: %s", e.Error())
+	}
+	commits := []FailedCommit{}
+	if e = json.Unmarshal(bs, &commits); e != nil {
+		return nil, fmt.Errorf("dead letter: %s", e.Error())
+	}
+	for _, fc := range commits {
+		s.commits[fc.Key] = fc
+	}
+	return s, nil
+}
+
+func (s *fileDeadLetter) Put(fc FailedCommit) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	old, existed := s.commits[fc.Key]
+	s.commits[fc.Key] = fc
+	if e := s.save(); e != nil {
+		if existed {
+			s.commits[fc.Key] = old
+		} else {
+			delete(s.commits, fc.Key)
+		}
+		return e
+	}
+	return nil
+}
+
+func (s *fileDeadLetter) List() ([]FailedCommit, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.list(), nil
+}
+
+func (s *fileDeadLetter) Remove(key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	fc, ok := s.commits[key]
+	if !ok {
+		return nil
+	}
+	delete(s.commits, key)
+	if e := s.save(); e != nil {
+		s.commits[key] = fc
+		return e
+	}
+	return nil
+}
+
+func (s *fileDeadLetter) list() []FailedCommit {
+	res := make([]FailedCommit, 0, len(s.commits))
+	for _, fc := range s.commits {
+		res = append(res, fc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+// save writes entries into temp file which then replaces the store, a crash leaves either old or new content
+func (s *fileDeadLetter) save() error {
+	bs, e := json.Marshal(s.list())
+	if e != nil {
+		return fmt.Errorf("dead letter: %s", e.Error())
+	}
+	f, e := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if e != nil {
+		return fmt.Errorf("dead letter: %s", e.Error())
+	}
+	tmpName := f.Name()
+	_, e = f.Write(bs)
+	if e == nil {
+		e = f.Sync()
+	}
+	if closeErr := f.Close(); e == nil {
+		e = closeErr
+	}
+	if e == nil {
+		e = os.Rename(tmpName, s.path)
+	}
+	if e != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("dead letter: %s", e.Error())
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package kiva_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileDeadLetter(t *testing.T) {
+	convey.Convey("file dead letter", t, func() {
+		path := filepath.Join(t.TempDir(), "dead.json")
+		store, e := kiva.NewFileDeadLetter(path)
+		convey.So(e, convey.ShouldBeNil)
+
+		convey.So(store.Put(kiva.FailedCommit{Key: "dl:B", Value: 2, Op: kiva.CommitSave, Attempts: 3}), convey.ShouldBeNil)
+		convey.So(store.Put(kiva.FailedCommit{Key: "dl:A", Op: kiva.CommitDelete, LastError: "db is down"}), convey.ShouldBeNil)
+		convey.So(store.Remove("dl:X"), convey.ShouldBeNil)
+
+		convey.Convey("entries survive reopen", func() {
+			reopened, e := kiva.NewFileDeadLetter(path)
+			convey.So(e, convey.ShouldBeNil)
+			commits, _ := reopened.List()
+			convey.So(len(commits), convey.ShouldEqual, 2)
+			convey.So(commits[0].Key, convey.ShouldEqual, "dl:A")
+			convey.So(commits[0].Op, convey.ShouldEqual, kiva.CommitDelete)
+			convey.So(commits[0].LastError, convey.ShouldEqual, "db is down")
+			convey.So(commits[1].Value, convey.ShouldEqual, 2)
+			convey.So(commits[1].Attempts, convey.ShouldEqual, 3)
+
+			convey.So(reopened.Remove("dl:A"), convey.ShouldBeNil)
+			reopened, _ = kiva.NewFileDeadLetter(path)
+			commits, _ = reopened.List()
+			convey.So(len(commits), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("corrupted file is reported", func() {
+			convey.So(os.WriteFile(path, []byte("{"), 0644), convey.ShouldBeNil)
+			_, e := kiva.NewFileDeadLetter(path)
+			convey.So(e, convey.ShouldNotBeNil)
+		})
+
+		convey.Convey("replay commits typed value of item on provider", func() {
+			var committed interface{}
+			down := true
+			committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+				if down {
+					return errors.New("db is down")
+				}
+				committed = value
+				return nil
+			}
+			store, _ := kiva.NewFileDeadLetter(filepath.Join(t.TempDir(), "replay.json"))
+			provider := kvsimple.New()
+			kv, e := kiva.NewCtx(context.Background(), provider, nil, nil, committer, &kiva.KivaOptions{
+				DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNow},
+				SyncBatch:    kiva.SyncBatchOptions{MaxAttempts: 1},
+				DeadLetter:   store,
+			})
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(kv.Set("dl:T", int64(7), nil, true), convey.ShouldNotBeNil)
+			convey.So(provider.ItemOpts("dl:T").SyncDirection, convey.ShouldEqual, kiva.SyncDeadLetter)
+
+			down = false
+			n, e := kv.ReplayDeadLetters(context.Background())
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(n, convey.ShouldEqual, 1)
+			convey.So(committed, convey.ShouldEqual, int64(7))
+			convey.So(provider.ItemOpts("dl:T").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+			commits, _ := store.List()
+			convey.So(commits, convey.ShouldBeEmpty)
+		})
+	})
+}
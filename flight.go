@@ -0,0 +1,84 @@
+package kiva
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type flightCall struct {
+	done  chan struct{}
+	value interface{}
+	opts  *ItemOptions
+	err   error
+
+	// cancelled is set when fn fails because context of the caller running it is done
+	cancelled bool
+}
+
+// flightGroup makes concurrent loads of the same key share one execution, callers arriving while
+// a load is running wait for it and receive its result instead of running their own
+type flightGroup struct {
+	mtx   sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do runs fn once per key at a time, shared is true when caller received result of other caller's fn.
+// A waiting caller gives up when its own ctx is done, and runs fn again when the call it waited for was
+// cancelled by context of its caller
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (interface{}, *ItemOptions, error)) (value interface{}, opts *ItemOptions, err error, shared bool) {
+	for {
+		g.mtx.Lock()
+		c, ok := g.calls[key]
+		if !ok {
+			break
+		}
+		g.mtx.Unlock()
+
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err(), true
+		}
+		if c.cancelled && ctx.Err() == nil {
+			continue
+		}
+		return c.value, c.opts, c.err, true
+	}
+
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mtx.Unlock()
+
+	defer func() {
+		g.mtx.Lock()
+		delete(g.calls, key)
+		g.mtx.Unlock()
+		close(c.done)
+	}()
+	g.run(ctx, c, fn)
+	return c.value, c.opts, c.err, false
+}
+
+// run calls fn and keeps its result on c, a panic of fn is returned as error so waiting callers are released
+func (g *flightGroup) run(ctx context.Context, c *flightCall, fn func() (interface{}, *ItemOptions, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.value, c.opts, c.err = nil, nil, fmt.Errorf("kv getter: panic: %v", r)
+		}
+	}()
+
+	c.value, c.opts, c.err = fn()
+	if c.err != nil {
+		c.cancelled = ctx.Err() != nil
+		return
+	}
+	if c.opts == nil {
+		c.err = errors.New("kv getter: item options is missing")
+	}
+}
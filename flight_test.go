@@ -0,0 +1,144 @@
+package kiva_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestSingleFlight(t *testing.T) {
+	convey.Convey("concurrent misses of the same key", t, func() {
+		var calls int64
+		release := make(chan struct{})
+		getter := func(ctx context.Context, key1, key2 string, op kiva.GetKind, dest interface{}) error {
+			atomic.AddInt64(&calls, 1)
+			<-release
+			if key1 == "flight:Missing" {
+				return errors.New("db is down")
+			}
+			*(dest.(*map[string]interface{})) = map[string]interface{}{"Name": "Loaded"}
+			return nil
+		}
+		kv, e := kiva.NewCtx(context.Background(), kvsimple.New(), nil, getter, nil, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute},
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		const readers = 10
+		readAll := func(key string) ([]map[string]interface{}, []error) {
+			results := make([]map[string]interface{}, readers)
+			errs := make([]error, readers)
+			wg := new(sync.WaitGroup)
+			for i := 0; i < readers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					dest := map[string]interface{}{}
+					errs[i] = kv.Get(key, &dest)
+					results[i] = dest
+				}(i)
+			}
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			return results, errs
+		}
+
+		convey.Convey("share one getter call", func() {
+			results, errs := readAll("flight:A")
+			convey.So(atomic.LoadInt64(&calls), convey.ShouldEqual, 1)
+			for i := range results {
+				convey.So(errs[i], convey.ShouldBeNil)
+				convey.So(results[i]["Name"], convey.ShouldEqual, "Loaded")
+			}
+			stats := kv.Stats()
+			convey.So(stats.GetterCalls, convey.ShouldEqual, 1)
+			convey.So(stats.GetterShared, convey.ShouldEqual, readers-1)
+		})
+
+		convey.Convey("share getter error", func() {
+			_, errs := readAll("flight:Missing")
+			convey.So(atomic.LoadInt64(&calls), convey.ShouldEqual, 1)
+			for _, e := range errs {
+				convey.So(e, convey.ShouldNotBeNil)
+			}
+			convey.So(kv.Stats().GetterShared, convey.ShouldEqual, readers-1)
+		})
+	})
+
+	convey.Convey("waiting callers keep their own context", t, func() {
+		started := make(chan struct{}, 2)
+		getter := func(ctx context.Context, key1, key2 string, op kiva.GetKind, dest interface{}) error {
+			started <- struct{}{}
+			if key1 == "flight:Panic" {
+				time.Sleep(50 * time.Millisecond)
+				panic("getter bug")
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(100 * time.Millisecond):
+			}
+			*(dest.(*map[string]interface{})) = map[string]interface{}{"Name": "Loaded"}
+			return nil
+		}
+		kv, e := kiva.NewCtx(context.Background(), kvsimple.New(), nil, getter, nil, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute},
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		convey.Convey("follower is not failed by cancelled leader", func() {
+			leaderCtx, cancel := context.WithCancel(context.Background())
+			leaderErr := make(chan error, 1)
+			go func() {
+				dest := map[string]interface{}{}
+				leaderErr <- kv.GetCtx(leaderCtx, "flight:B", &dest)
+			}()
+			<-started
+
+			followerErr := make(chan error, 1)
+			dest := map[string]interface{}{}
+			go func() {
+				followerErr <- kv.GetCtx(context.Background(), "flight:B", &dest)
+			}()
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+
+			convey.So(<-leaderErr, convey.ShouldNotBeNil)
+			convey.So(<-followerErr, convey.ShouldBeNil)
+			convey.So(dest["Name"], convey.ShouldEqual, "Loaded")
+		})
+
+		convey.Convey("follower gives up on its own deadline", func() {
+			go func() {
+				dest := map[string]interface{}{}
+				kv.Get("flight:C", &dest)
+			}()
+			<-started
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			dest := map[string]interface{}{}
+			convey.So(errors.Is(kv.GetCtx(ctx, "flight:C", &dest), context.DeadlineExceeded), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("getter panic is returned as error to all callers", func() {
+			errs := make(chan error, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					dest := map[string]interface{}{}
+					errs <- kv.Get("flight:Panic", &dest)
+				}()
+			}
+			convey.So(<-errs, convey.ShouldNotBeNil)
+			convey.So(<-errs, convey.ShouldNotBeNil)
+		})
+	})
+}
@@ -1,18 +1,29 @@
 package kiva
 
 import (
+	"sync/atomic"
 	"time"
 )
 
 type ExpiryKindEnum string
 type SyncDirectionEnum string
 
+// ItemKindEnum tells what an item holds, empty kind is a regular item
+type ItemKindEnum string
+
 const (
 	ExpiryAbsolute ExpiryKindEnum = "ABSOLUTE"
 	ExpiryExtended ExpiryKindEnum = "EXTENDED"
 
 	SyncToPersistent SyncDirectionEnum = "UPDATE_PERSISTENT"
 	SyncToHots       SyncDirectionEnum = "UPDATE_HOT_STORAGE"
+	// SyncDeadLetter marks item of which commit has failed too many times, it is left to DeadLetterStore
+	SyncDeadLetter SyncDirectionEnum = "DEAD_LETTER"
+
+	// ItemNegative marks key which is known to be missing on persistent storage
+	ItemNegative ItemKindEnum = "NEGATIVE"
+	// ItemTombstone marks key deleted on cache of which delete is waiting to be committed
+	ItemTombstone ItemKindEnum = "TOMBSTONE"
 )
 
 type ItemOptions struct {
@@ -23,4 +34,85 @@ type ItemOptions struct {
 	SyncKind             SyncKindEnum
 	SyncEveryInSecond    int
 	LastSync             time.Time
+	Kind                 ItemKindEnum
+	GracePeriod          time.Duration
+	// Version changes on every write of item, commit marks item as synced only when it still holds
+	// the version which has been committed
+	Version int64
+
+	// write-behind state of item waiting to be committed
+	CommitAttempts  int
+	NextCommit      time.Time
+	LastCommitError string
+}
+
+// NewItemOptions builds the options of a freshly written item, it is used by providers on Set
+func NewItemOptions(opts *WriteOptions) *ItemOptions {
+	now := time.Now()
+	return &ItemOptions{
+		Expiry:               now.Add(opts.TTL),
+		SyncDirection:        SyncToPersistent,
+		ExpiryKind:           opts.ExpiryKind,
+		ExpiryExtendDuration: opts.TTL,
+		SyncKind:             opts.SyncKind,
+		SyncEveryInSecond:    opts.SyncEveryInSecond,
+		LastSync:             now,
+		Kind:                 opts.Kind,
+		GracePeriod:          opts.GracePeriod,
+		Version:              nextVersion(),
+	}
+}
+
+var lastVersion int64
+
+// nextVersion returns increasing version based on clock, so versions of items written by other processes
+// on a shared provider do not collide either
+func nextVersion() int64 {
+	for {
+		last := atomic.LoadInt64(&lastVersion)
+		v := time.Now().UnixNano()
+		if v <= last {
+			v = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastVersion, last, v) {
+			return v
+		}
+	}
+}
+
+// RetainUntil is the time an item may be dropped by provider, expired item is kept for GracePeriod
+// to be served when persistent storage is not reachable
+func (o *ItemOptions) RetainUntil() time.Time {
+	return o.Expiry.Add(o.GracePeriod)
+}
+
+// ApplySync copies sync related attributes from src, it is used by providers on ChangeSyncOpts
+func (o *ItemOptions) ApplySync(src *ItemOptions) {
+	o.SyncDirection = src.SyncDirection
+	o.SyncKind = src.SyncKind
+	o.SyncEveryInSecond = src.SyncEveryInSecond
+	o.CommitAttempts = src.CommitAttempts
+	o.NextCommit = src.NextCommit
+	o.LastCommitError = src.LastCommitError
+	o.Kind = src.Kind
+}
+
+// Pending tells item holds a write waiting to be committed, item without sync has nothing to commit
+// and may be dropped by capacity bound providers
+func (o *ItemOptions) Pending() bool {
+	return o.SyncDirection == SyncToPersistent && o.SyncKind != SyncNone
+}
+
+// Hidden tells item is a marker which is not visible to reads
+func (o *ItemOptions) Hidden() bool {
+	return o.Kind == ItemNegative || o.Kind == ItemTombstone
+}
+
+// MarkSynced records item has been synced with persistent storage, it is used by providers on UpdateLastSyncTime
+func (o *ItemOptions) MarkSynced() {
+	o.LastSync = time.Now()
+	o.SyncDirection = SyncToHots
+	o.CommitAttempts = 0
+	o.NextCommit = time.Time{}
+	o.LastCommitError = ""
 }
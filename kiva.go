@@ -4,82 +4,286 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/ariefdarmawan/serde"
 )
 
 type GetterFunc func(key1, key2 string, op GetKind, dest interface{}) error
 type CommitFunc func(key1 string, value interface{}, op CommitKind) error
 type ItemReflectorFunc func(tablename string) interface{}
 
+// GetterCtxFunc is GetterFunc which receives context of the request, e.g. to cancel a slow database read
+type GetterCtxFunc func(ctx context.Context, key1, key2 string, op GetKind, dest interface{}) error
+
+// CommitCtxFunc is CommitFunc which receives context of the request or of the sync process
+type CommitCtxFunc func(ctx context.Context, key1 string, value interface{}, op CommitKind) error
+
+// ErrNotFound is returned by Get when key exists neither on provider nor on persistent storage
+var ErrNotFound = errors.New("key not found")
+
+// ErrStale is returned by Get along with expired item when the item is within its grace period
+// and getter fails to reload it, dest holds the expired item
+var ErrStale = errors.New("item is stale")
+
 type Kiva struct {
 	provider  Provider
-	commiter  CommitFunc
-	getter    GetterFunc
+	commiter  CommitCtxFunc
+	getter    GetterCtxFunc
 	reflector ItemReflectorFunc
 
-	opts *KivaOptions
+	tableReflectors map[string]ItemReflectorFunc
+	tableMtx        *sync.RWMutex
+
+	opts    *KivaOptions
+	optsMtx sync.RWMutex
+
+	flight    *flightGroup
+	refresher *refresher
+	stats     stats
+
+	deadLetter DeadLetterStore
+	coalescer  *coalescer
+	scheduler  *scheduler
+	keyLocks   keyLocks
+	policies   policies
+	markers    *markers
+
+	stop      chan struct{}
+	loopDone  chan struct{}
+	closeOnce sync.Once
+	wake      chan struct{}
+	paused    int32
+	lastPass  int64
 
 	ctx context.Context
 }
 
 func New(provider Provider, reflector ItemReflectorFunc, getter GetterFunc, committer CommitFunc, opts *KivaOptions) (*Kiva, error) {
+	var (
+		getterCtx    GetterCtxFunc
+		committerCtx CommitCtxFunc
+	)
+	if getter != nil {
+		getterCtx = func(_ context.Context, key1, key2 string, op GetKind, dest interface{}) error {
+			return getter(key1, key2, op, dest)
+		}
+	}
+	if committer != nil {
+		committerCtx = func(_ context.Context, key1 string, value interface{}, op CommitKind) error {
+			return committer(key1, value, op)
+		}
+	}
+	return NewCtx(context.Background(), provider, reflector, getterCtx, committerCtx, opts)
+}
+
+// NewCtx creates Kiva with context aware getter and committer. ctx is the lifetime of Kiva, background sync
+// stops when it is done, and it is used by methods not receiving context
+func NewCtx(ctx context.Context, provider Provider, reflector ItemReflectorFunc, getter GetterCtxFunc, committer CommitCtxFunc, opts *KivaOptions) (*Kiva, error) {
 	if e := provider.Connect(); e != nil {
 		return nil, errors.New("unable to connect to provider. " + e.Error())
 	}
 
 	k := new(Kiva)
-	k.ctx = context.Background()
+	k.ctx = ctx
 	k.provider = provider
 	k.getter = getter
 	k.commiter = committer
 	k.reflector = reflector
+	k.tableReflectors = make(map[string]ItemReflectorFunc)
+	k.tableMtx = new(sync.RWMutex)
 	k.opts = opts
+	k.flight = newFlightGroup()
+	k.refresher = newRefresher(opts.Refresh.MaxConcurrent)
+	k.coalescer = newCoalescer(opts.Coalesce)
+	k.scheduler = newScheduler()
+	k.markers = newMarkers()
+	k.deadLetter = opts.DeadLetter
+	if k.deadLetter == nil {
+		k.deadLetter = NewMemoryDeadLetter()
+	}
+
+	k.stop = make(chan struct{})
+	k.wake = make(chan struct{}, 1)
+	k.loopDone = make(chan struct{})
 
 	k.provider.SetContext(k.ctx)
 
-	if k.opts.SyncBatch.EveryInSecond > 0 {
-		go k.Sync()
-	}
+	// loop idles while SyncBatch.EveryInSecond is 0, it may be enabled later by SetSyncOptions
+	go func() {
+		defer close(k.loopDone)
+		k.Sync()
+	}()
 
 	return k, nil
 }
 
 func (k *Kiva) Get(key string, dest interface{}) error {
-	opts, e := k.provider.Get(key, dest)
+	return k.GetCtx(k.ctx, key, dest)
+}
+
+func (k *Kiva) GetCtx(ctx context.Context, key string, dest interface{}) error {
+	opts, e := ProviderGet(ctx, k.provider, key, dest)
+	if e == nil && opts.Kind == ItemTombstone {
+		return ErrNotFound
+	}
+	if e == nil && opts.Kind == ItemNegative {
+		if opts.Expiry.After(time.Now()) {
+			return ErrNotFound
+		}
+		ProviderDelete(ctx, k.provider, key)
+		e = io.EOF
+	}
 	if e != nil {
 		if k.getter == nil {
 			return fmt.Errorf("kv getter: invalid getter")
 		}
-		if e = k.getter(key, "", GetByID, dest); e != nil {
-			return fmt.Errorf("kv getter: %s", e.Error())
+		if opts, e = k.load(ctx, key, dest); e != nil {
+			return e
 		}
-		if e != nil {
-			return fmt.Errorf("kv getter: %s", e.Error())
+	}
+	now := time.Now()
+	if k.refreshAhead(opts, now) {
+		k.refreshAsync(key)
+	}
+	if opts.ExpiryKind == ExpiryExtended {
+		opts.Expiry = opts.Expiry.Add(opts.ExpiryExtendDuration)
+	}
+	if opts.Expiry.Before(now) {
+		stale := now.Before(opts.Expiry.Add(opts.GracePeriod))
+		if k.opts.Refresh.StaleWhileRevalidate && stale && k.canRefresh(opts) {
+			k.refreshAsync(key)
+			return nil
 		}
+		if stale && k.canRefresh(opts) {
+			return k.reloadOrStale(ctx, key, dest)
+		}
+		ProviderDelete(ctx, k.provider, key)
+		return errors.New("item is expired")
+	}
+	return nil
+}
 
-		destValue := reflect.Indirect(reflect.ValueOf(dest)).Interface()
-		if e = k.provider.Set(key, destValue, &k.opts.DefaultWrite); e != nil {
-			return fmt.Errorf("kv setter: %s", e.Error())
+// load reads key thru getter and writes it to provider. Concurrent loads of the same key share
+// one getter call, the callers which did not run the getter receive a copy of its result
+func (k *Kiva) load(ctx context.Context, key string, dest interface{}) (*ItemOptions, error) {
+	value, opts, e, shared := k.flight.do(ctx, key, func() (interface{}, *ItemOptions, error) {
+		atomic.AddInt64(&k.stats.getterCalls, 1)
+		if e := k.getter(ctx, key, "", GetByID, dest); e != nil {
+			if errors.Is(e, io.EOF) {
+				k.markMissing(ctx, key, dest)
+				return nil, nil, ErrNotFound
+			}
+			return nil, nil, fmt.Errorf("kv getter: %s", e.Error())
 		}
-		opts = &ItemOptions{
-			Expiry:        time.Now().Add(k.opts.DefaultWrite.TTL),
-			SyncDirection: SyncToHots,
-			ExpiryKind:    k.opts.DefaultWrite.ExpiryKind,
-			SyncKind:      k.opts.DefaultWrite.SyncKind,
+
+		opts, e := k.storeLoaded(ctx, key, dest)
+		if e != nil {
+			return nil, nil, e
 		}
+		return reflect.Indirect(reflect.ValueOf(dest)).Interface(), opts, nil
+	})
+	if shared {
+		atomic.AddInt64(&k.stats.getterShared, 1)
 	}
-	if opts.ExpiryKind == ExpiryExtended {
-		opts.Expiry = opts.Expiry.Add(opts.ExpiryExtendDuration)
+	if e != nil {
+		return nil, e
 	}
-	if opts.Expiry.Before(time.Now()) {
-		k.provider.Delete(key)
-		return errors.New("item is expired")
+	if shared {
+		if e = serde.Serde(value, dest); e != nil {
+			return nil, fmt.Errorf("kv getter: %s", e.Error())
+		}
+	}
+	optsCopy := *opts
+	return &optsCopy, nil
+}
+
+// storeLoaded writes value just read from persistent storage in dest to provider. Key is not locked while
+// getter runs, when it is written meanwhile the write is newer and kept, dest receives it instead
+func (k *Kiva) storeLoaded(ctx context.Context, key string, dest interface{}) (*ItemOptions, error) {
+	unlock := k.keyLocks.lock(key)
+	defer unlock()
+	if current := k.provider.ItemOpts(key); current != nil && unsaved(current) {
+		if current.Kind == ItemTombstone {
+			return nil, ErrNotFound
+		}
+		return ProviderGet(ctx, k.provider, key, dest)
+	}
+
+	writeOpts := k.retained(k.writeOptions(key))
+	if e := ProviderSet(ctx, k.provider, key, reflect.Indirect(reflect.ValueOf(dest)).Interface(), writeOpts); e != nil {
+		return nil, fmt.Errorf("kv setter: %s", e.Error())
+	}
+	// item is just read from persistent storage, nothing to commit back
+	k.provider.UpdateLastSyncTime(key)
+	k.scheduleRefresh(key, writeOpts)
+	return &ItemOptions{
+		Expiry:        time.Now().Add(writeOpts.TTL),
+		SyncDirection: SyncToHots,
+		ExpiryKind:    writeOpts.ExpiryKind,
+		SyncKind:      writeOpts.SyncKind,
+	}, nil
+}
+
+// unsaved tells item holds a write not committed to persistent storage yet
+func unsaved(opts *ItemOptions) bool {
+	return opts.SyncDirection == SyncToPersistent || opts.SyncDirection == SyncDeadLetter
+}
+
+// reloadOrStale reloads expired item into dest, dest is left holding the expired item when getter fails
+func (k *Kiva) reloadOrStale(ctx context.Context, key string, dest interface{}) error {
+	fresh := reflect.New(reflect.TypeOf(dest).Elem()).Interface()
+	if _, e := k.load(ctx, key, fresh); e != nil {
+		if e == ErrNotFound {
+			return e
+		}
+		return fmt.Errorf("%w. %s", ErrStale, e.Error())
 	}
+	reflect.ValueOf(dest).Elem().Set(reflect.ValueOf(fresh).Elem())
 	return nil
 }
 
+// markMissing removes key which getter reports as missing, when NegativeTTL is set key is remembered
+// as missing. Value of the marker is zero value of dest type so byte providers are able to decode it into the same dest.
+// Key written while getter runs is kept
+func (k *Kiva) markMissing(ctx context.Context, key string, dest interface{}) {
+	rv := reflect.Indirect(reflect.ValueOf(dest))
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	unlock := k.keyLocks.lock(key)
+	defer unlock()
+	if current := k.provider.ItemOpts(key); current != nil && unsaved(current) {
+		return
+	}
+	if k.opts.NegativeTTL <= 0 || !rv.IsValid() {
+		ProviderDelete(ctx, k.provider, key)
+		return
+	}
+	zero := reflect.Zero(rv.Type()).Interface()
+	opts := &WriteOptions{
+		TTL:        k.opts.NegativeTTL,
+		SyncKind:   SyncNone,
+		ExpiryKind: ExpiryAbsolute,
+		Kind:       ItemNegative,
+	}
+	if e := ProviderSet(ctx, k.provider, key, zero, opts); e != nil {
+		return
+	}
+	k.addMarker(key)
+	// marker has nothing to persist, it is marked as synced so capacity bound providers may evict it
+	k.provider.UpdateLastSyncTime(key)
+}
+
 func (k *Kiva) GetByPattern(pattern string, dest interface{}, runGetterIfEmpty bool) error {
+	return k.GetByPatternCtx(k.ctx, pattern, dest, runGetterIfEmpty)
+}
+
+func (k *Kiva) GetByPatternCtx(ctx context.Context, pattern string, dest interface{}, runGetterIfEmpty bool) error {
 	rv := reflect.ValueOf(dest)
 	if rv.Kind() != reflect.Ptr {
 		return fmt.Errorf("output should be ptr of slice")
@@ -89,15 +293,16 @@ func (k *Kiva) GetByPattern(pattern string, dest interface{}, runGetterIfEmpty b
 		return fmt.Errorf("output should be ptr of slice")
 	}
 
-	keys := k.Keys(pattern)
-	if e := k.getByKeys(dest, keys...); e != nil {
+	// markers are left out by getByKeys, which reads their options anyway
+	keys := ProviderKeys(ctx, k.provider, pattern)
+	if e := k.getByKeys(ctx, dest, keys...); e != nil {
 		return fmt.Errorf("getter error: %s", e.Error())
 	}
 
 	if runGetterIfEmpty {
 		destLen := rv.Elem().Len()
 		if destLen == 0 && k.getter != nil {
-			if e := k.getter(pattern, "", GetByPattern, dest); e != nil {
+			if e := k.getter(ctx, pattern, "", GetByPattern, dest); e != nil {
 				return fmt.Errorf("getter error: %s", e.Error())
 			}
 		}
@@ -106,6 +311,10 @@ func (k *Kiva) GetByPattern(pattern string, dest interface{}, runGetterIfEmpty b
 }
 
 func (k *Kiva) GetRange(from, to string, dest interface{}, runGetterIfEmpty bool) error {
+	return k.GetRangeCtx(k.ctx, from, to, dest, runGetterIfEmpty)
+}
+
+func (k *Kiva) GetRangeCtx(ctx context.Context, from, to string, dest interface{}, runGetterIfEmpty bool) error {
 	rv := reflect.ValueOf(dest)
 	if rv.Kind() != reflect.Ptr {
 		return fmt.Errorf("output should be ptr of slice")
@@ -115,15 +324,15 @@ func (k *Kiva) GetRange(from, to string, dest interface{}, runGetterIfEmpty bool
 		return fmt.Errorf("output should be ptr of slice")
 	}
 
-	keys := k.KeyRanges(from, to)
-	if e := k.getByKeys(dest, keys...); e != nil {
+	keys := ProviderKeyRanges(ctx, k.provider, from, to)
+	if e := k.getByKeys(ctx, dest, keys...); e != nil {
 		return fmt.Errorf("getter error: %s", e.Error())
 	}
 
 	if runGetterIfEmpty {
 		destLen := rv.Elem().Len()
 		if destLen == 0 && k.getter != nil {
-			if e := k.getter(from, to, GetRange, dest); e != nil {
+			if e := k.getter(ctx, from, to, GetRange, dest); e != nil {
 				return fmt.Errorf("getter error: %s", e.Error())
 			}
 		}
@@ -131,64 +340,175 @@ func (k *Kiva) GetRange(from, to string, dest interface{}, runGetterIfEmpty bool
 	return nil
 }
 
-func (k *Kiva) getByKeys(dest interface{}, keys ...string) error {
+func (k *Kiva) getByKeys(ctx context.Context, dest interface{}, keys ...string) error {
 	rtSlice := reflect.TypeOf(dest).Elem()
 	rtElem := rtSlice.Elem()
 
-	buffers := reflect.MakeSlice(rtSlice, len(keys), len(keys))
+	values := make([]reflect.Value, len(keys))
+	missing := []string{}
 	for i, key := range keys {
+		if e := ctx.Err(); e != nil {
+			return e
+		}
 		newElem := reflect.New(rtElem).Interface()
-		var (
-			err error
-		)
-		if _, err = k.provider.Get(key, newElem); err != nil {
+		opts, err := ProviderGet(ctx, k.provider, key, newElem)
+		if err != nil {
+			// negative marker may hold value of other type than dest
+			if itemOpts := k.provider.ItemOpts(key); itemOpts != nil && itemOpts.Hidden() {
+				continue
+			}
+			if k.opts.BatchGetter != nil {
+				missing = append(missing, key)
+				continue
+			}
 			return fmt.Errorf("read data erorr. key %s. %s", key, err.Error())
 		}
-		buffers.Index(i).Set(reflect.ValueOf(newElem).Elem())
+		if opts.Hidden() {
+			continue
+		}
+		values[i] = reflect.ValueOf(newElem).Elem()
+	}
+
+	// keys gone from provider since they are listed are loaded together
+	if len(missing) > 0 {
+		found, e := k.loadMany(ctx, missing, rtElem)
+		if e != nil {
+			return e
+		}
+		for i, key := range keys {
+			if v, ok := found[key]; ok {
+				values[i] = v
+			}
+		}
+	}
+
+	buffers := reflect.MakeSlice(rtSlice, 0, len(keys))
+	for _, v := range values {
+		if v.IsValid() {
+			buffers = reflect.Append(buffers, v)
+		}
 	}
 	reflect.ValueOf(dest).Elem().Set(buffers)
 	return nil
 }
 
 func (k *Kiva) Set(key string, value interface{}, opts *WriteOptions, syncToDB bool) error {
+	return k.SetCtx(k.ctx, key, value, opts, syncToDB)
+}
+
+// SetCtx writes item to provider, write and its commit are not interleaved with sync of the same key
+func (k *Kiva) SetCtx(ctx context.Context, key string, value interface{}, opts *WriteOptions, syncToDB bool) error {
+	unlock := k.keyLocks.lock(key)
+	defer unlock()
+	return k.set(ctx, key, value, opts, syncToDB)
+}
+
+// set is SetCtx for caller holding lock of key
+func (k *Kiva) set(ctx context.Context, key string, value interface{}, opts *WriteOptions, syncToDB bool) error {
 	if opts == nil {
-		opts = &k.opts.DefaultWrite
+		opts = k.writeOptions(key)
 	}
-	if e := k.provider.Set(key, value, opts); e != nil {
+	if e := ProviderSet(ctx, k.provider, key, value, k.retained(opts)); e != nil {
 		return e
 	}
-	if (syncToDB && opts.SyncKind == SyncNow) && k.commiter != nil {
-		if e := k.commiter(key, value, CommitSave); e != nil {
+	if opts.SyncKind != SyncNone {
+		k.markDirty(key)
+	}
+	if (syncToDB && opts.SyncKind == SyncNow) && k.hasCommitter() {
+		if k.coalescer.enabled() {
+			k.coalesce(key)
+			return nil
+		}
+		itemOpts := k.provider.ItemOpts(key)
+		if itemOpts == nil {
+			itemOpts = NewItemOptions(opts)
+		}
+		if e := k.commit(ctx, key, value, CommitSave, itemOpts); e != nil {
 			return fmt.Errorf("commit error. %s", e.Error())
 		}
-		k.provider.UpdateLastSyncTime(key)
 	}
 	return nil
 }
 
 func (k *Kiva) Delete(syncToDB bool, keys ...string) {
+	k.DeleteCtx(k.ctx, syncToDB, keys...)
+}
+
+func (k *Kiva) DeleteCtx(ctx context.Context, syncToDB bool, keys ...string) {
 	for _, key := range keys {
-		k.provider.Delete(key)
-		if syncToDB && k.commiter != nil {
-			k.commiter(key, nil, CommitDelete)
-		}
+		k.delete(ctx, key, syncToDB)
+	}
+}
+
+func (k *Kiva) delete(ctx context.Context, key string, syncToDB bool) {
+	unlock := k.keyLocks.lock(key)
+	defer unlock()
+	if syncToDB && k.hasCommitter() && k.deferDelete(key) && k.deleteDeferred(ctx, key) {
+		k.markDirty(key)
+		return
+	}
+	k.scheduler.remove(key)
+	ProviderDelete(ctx, k.provider, key)
+	if syncToDB && k.hasCommitter() {
+		k.commitOne(ctx, key, nil, CommitDelete)
 	}
 }
 
 func (k *Kiva) DeleteRange(from, to string, syncToDB bool) {
-	keys := k.KeyRanges(from, to)
-	k.Delete(syncToDB, keys...)
+	k.DeleteRangeCtx(k.ctx, from, to, syncToDB)
+}
+
+func (k *Kiva) DeleteRangeCtx(ctx context.Context, from, to string, syncToDB bool) {
+	keys := k.KeyRangesCtx(ctx, from, to)
+	k.DeleteCtx(ctx, syncToDB, keys...)
 }
 
 func (k *Kiva) DeleteByPattern(pattern string, syncToDB bool) {
-	keys := k.Keys(pattern)
-	k.Delete(syncToDB, keys...)
+	k.DeleteByPatternCtx(k.ctx, pattern, syncToDB)
+}
+
+func (k *Kiva) DeleteByPatternCtx(ctx context.Context, pattern string, syncToDB bool) {
+	keys := k.KeysCtx(ctx, pattern)
+	k.DeleteCtx(ctx, syncToDB, keys...)
 }
 
 func (k *Kiva) Keys(pattern string) []string {
-	return k.provider.Keys(pattern)
+	return k.KeysCtx(k.ctx, pattern)
+}
+
+// KeysCtx returns keys matching pattern, keys of tombstones and negative markers are left out
+func (k *Kiva) KeysCtx(ctx context.Context, pattern string) []string {
+	return k.visibleKeys(ctx, ProviderKeys(ctx, k.provider, pattern))
 }
 
 func (k *Kiva) KeyRanges(from, to string) []string {
-	return k.provider.KeyRanges(from, to)
+	return k.KeyRangesCtx(k.ctx, from, to)
+}
+
+func (k *Kiva) KeyRangesCtx(ctx context.Context, from, to string) []string {
+	return k.visibleKeys(ctx, ProviderKeyRanges(ctx, k.provider, from, to))
+}
+
+// RegisterTable sets reflector of a table, it takes precedence over reflector given on New.
+// Reflector registered here should return pointer of new item
+func (k *Kiva) RegisterTable(tableName string, reflector ItemReflectorFunc) {
+	k.tableMtx.Lock()
+	defer k.tableMtx.Unlock()
+	k.tableReflectors[tableName] = reflector
+}
+
+// newItem returns pointer to a new item of given table, it is used as destination when item is read on sync
+func (k *Kiva) newItem(tableName string) interface{} {
+	k.tableMtx.RLock()
+	reflector, ok := k.tableReflectors[tableName]
+	k.tableMtx.RUnlock()
+	if ok {
+		return reflector(tableName)
+	}
+
+	var item interface{}
+	if k.reflector != nil {
+		item = k.reflector(tableName)
+	}
+	return &item
 }
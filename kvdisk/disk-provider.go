@@ -0,0 +1,508 @@
+package kvdisk
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sebarcode/kiva"
+)
+
+type Options struct {
+	// Dir is the folder holding segment files, it is created on Connect when not exist
+	Dir string
+
+	// SegmentSize is the size on which active segment is rotated, default is 64MB
+	SegmentSize int64
+
+	// CompactInterval is how often garbage ratio is checked by background compaction, default is 5 minutes.
+	// Negative value disables background compaction, Compact can still be called manually
+	CompactInterval time.Duration
+
+	// CompactRatio is the garbage to total bytes ratio triggering compaction, default is 0.5
+	CompactRatio float64
+
+	// SyncWrites calls fsync after every write, otherwise fsync is done on rotation, compaction and Close
+	SyncWrites bool
+
+	// Codecs encodes values by table, default is JSON
+	Codecs kiva.Codecs
+}
+
+type indexEntry struct {
+	opts      *kiva.ItemOptions
+	segment   uint32
+	offset    int64
+	size      int
+	putBytes  int64
+	optsBytes int64
+}
+
+// DiskProvider keeps items on append-only segment files. Every Set, option change and Delete is appended
+// as a record, latest state of each key is hold by an in-memory ordered index which is rebuilt by replaying
+// segments on Connect. Superseded records are removed by compaction
+type DiskProvider struct {
+	opts *Options
+
+	keys       []string
+	index      map[string]*indexEntry
+	segments   map[uint32]*os.File
+	active     uint32
+	activeSize int64
+	totalBytes int64
+	liveBytes  int64
+
+	mtx  *sync.RWMutex
+	stop chan bool
+	done chan bool
+	ctx  context.Context
+}
+
+func New(opts *Options) kiva.Provider {
+	p := new(DiskProvider)
+	p.opts = new(Options)
+	if opts != nil {
+		*p.opts = *opts
+	}
+	if p.opts.Dir == "" {
+		p.opts.Dir = "kiva-data"
+	}
+	if p.opts.SegmentSize <= 0 {
+		p.opts.SegmentSize = 64 << 20
+	}
+	if p.opts.CompactInterval == 0 {
+		p.opts.CompactInterval = 5 * time.Minute
+	}
+	if p.opts.CompactRatio <= 0 {
+		p.opts.CompactRatio = 0.5
+	}
+	p.mtx = new(sync.RWMutex)
+	return p
+}
+
+// Connect opens data folder and rebuilds index by replaying all segments, a torn record at the tail
+// of a segment (e.g. process crash during write) is truncated
+func (p *DiskProvider) Connect() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if e := os.MkdirAll(p.opts.Dir, 0755); e != nil {
+		return fmt.Errorf("create dir: %s", e.Error())
+	}
+	ids, e := listSegments(p.opts.Dir)
+	if e != nil {
+		return fmt.Errorf("list segments: %s", e.Error())
+	}
+
+	p.keys = []string{}
+	p.index = make(map[string]*indexEntry)
+	p.segments = make(map[uint32]*os.File)
+	p.totalBytes, p.liveBytes = 0, 0
+
+	for _, id := range ids {
+		if e = p.replay(id); e != nil {
+			p.closeFiles()
+			return fmt.Errorf("replay segment %d: %s", id, e.Error())
+		}
+	}
+
+	if len(ids) == 0 {
+		if e = p.openSegment(1); e != nil {
+			return e
+		}
+	} else {
+		p.active = ids[len(ids)-1]
+		st, e := p.segments[p.active].Stat()
+		if e != nil {
+			p.closeFiles()
+			return e
+		}
+		p.activeSize = st.Size()
+	}
+
+	if p.opts.CompactInterval > 0 {
+		p.stop = make(chan bool)
+		p.done = make(chan bool)
+		go p.compactLoop(p.stop, p.done)
+	}
+	return nil
+}
+
+// Close stops background compaction, flushes and closes all segment files
+func (p *DiskProvider) Close() {
+	if p.stop != nil {
+		close(p.stop)
+		<-p.done
+		p.stop = nil
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.closeFiles()
+}
+
+func (p *DiskProvider) Context() context.Context {
+	if p.ctx == nil {
+		p.ctx = context.Background()
+		return p.ctx
+	}
+	return p.ctx
+}
+
+func (p *DiskProvider) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+func (p *DiskProvider) Set(key string, value interface{}, opts *kiva.WriteOptions) error {
+	if opts == nil {
+		opts = &kiva.WriteOptions{}
+	}
+
+	bs, e := p.opts.Codecs.Of(key).Encode(value)
+	if e != nil {
+		return fmt.Errorf("encode: %s", e.Error())
+	}
+	itemOpts := kiva.NewItemOptions(opts)
+	optsBs, e := json.Marshal(itemOpts)
+	if e != nil {
+		return fmt.Errorf("encode options: %s", e.Error())
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.write(&record{op: opPut, key: key, opts: optsBs, value: bs}, itemOpts)
+}
+
+func (p *DiskProvider) Get(key string, dest interface{}) (*kiva.ItemOptions, error) {
+	p.mtx.RLock()
+	entry, ok := p.index[key]
+	if !ok {
+		p.mtx.RUnlock()
+		return nil, io.EOF
+	}
+	bs := make([]byte, entry.size)
+	_, e := p.segments[entry.segment].ReadAt(bs, entry.offset)
+	opts := *entry.opts
+	p.mtx.RUnlock()
+
+	if e != nil {
+		return nil, fmt.Errorf("read: %s", e.Error())
+	}
+	if e = p.opts.Codecs.Of(key).Decode(bs, dest); e != nil {
+		return nil, fmt.Errorf("cast: %s", e.Error())
+	}
+	return &opts, nil
+}
+
+func (p *DiskProvider) HasKey(key string) bool {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	_, ok := p.index[key]
+	return ok
+}
+
+func (p *DiskProvider) Delete(key string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if _, ok := p.index[key]; !ok {
+		return
+	}
+	p.write(&record{op: opDelete, key: key}, nil)
+}
+
+func (p *DiskProvider) Keys(pattern string) []string {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	keys := []string{}
+	if pattern == "*" {
+		return append(keys, p.keys...)
+	}
+	pattern = strings.TrimSuffix(pattern, "*")
+	for i := sort.SearchStrings(p.keys, pattern); i < len(p.keys) && strings.HasPrefix(p.keys[i], pattern); i++ {
+		keys = append(keys, p.keys[i])
+	}
+	return keys
+}
+
+func (p *DiskProvider) KeyRanges(from string, to string) []string {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	inRangeKeys := []string{}
+	for i := sort.SearchStrings(p.keys, from); i < len(p.keys) && strings.Compare(p.keys[i], to) <= 0; i++ {
+		inRangeKeys = append(inRangeKeys, p.keys[i])
+	}
+	return inRangeKeys
+}
+
+func (p *DiskProvider) ChangeSyncOpts(key string, opts *kiva.ItemOptions) error {
+	return p.updateOpts(key, func(itemOpts *kiva.ItemOptions) {
+		itemOpts.ApplySync(opts)
+	})
+}
+
+func (p *DiskProvider) RenewExpiry(key string) error {
+	return p.updateOpts(key, func(itemOpts *kiva.ItemOptions) {
+		itemOpts.Expiry = time.Now().Add(itemOpts.ExpiryExtendDuration)
+	})
+}
+
+func (p *DiskProvider) UpdateLastSyncTime(key string) error {
+	return p.updateOpts(key, func(itemOpts *kiva.ItemOptions) {
+		itemOpts.MarkSynced()
+	})
+}
+
+func (p *DiskProvider) ItemOpts(key string) *kiva.ItemOptions {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	entry, ok := p.index[key]
+	if !ok {
+		return nil
+	}
+	opts := *entry.opts
+	return &opts
+}
+
+// Compact rewrites live records into a new segment and removes older segments.
+// Compacted segment is fully written and flushed before old segments are removed, so a crash
+// in the middle of compaction leaves a log which still replays into the same state
+func (p *DiskProvider) Compact() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.segments == nil {
+		return errors.New("provider is not connected")
+	}
+	if p.totalBytes == p.liveBytes {
+		return nil
+	}
+
+	oldIDs := make([]uint32, 0, len(p.segments))
+	for id := range p.segments {
+		oldIDs = append(oldIDs, id)
+	}
+	sort.Slice(oldIDs, func(i, j int) bool { return oldIDs[i] < oldIDs[j] })
+
+	if e := p.rotate(); e != nil {
+		return e
+	}
+	for _, key := range p.keys {
+		entry := p.index[key]
+		bs := make([]byte, entry.size)
+		if _, e := p.segments[entry.segment].ReadAt(bs, entry.offset); e != nil {
+			return fmt.Errorf("compact: read %s: %s", key, e.Error())
+		}
+		optsBs, e := json.Marshal(entry.opts)
+		if e != nil {
+			return fmt.Errorf("compact: encode options %s: %s", key, e.Error())
+		}
+		if e = p.write(&record{op: opPut, key: key, opts: optsBs, value: bs}, entry.opts); e != nil {
+			return fmt.Errorf("compact: %s", e.Error())
+		}
+	}
+	if e := p.segments[p.active].Sync(); e != nil {
+		return fmt.Errorf("compact: sync: %s", e.Error())
+	}
+
+	for _, id := range oldIDs {
+		p.segments[id].Close()
+		delete(p.segments, id)
+		if e := os.Remove(segmentName(p.opts.Dir, id)); e != nil {
+			return fmt.Errorf("compact: remove segment %d: %s", id, e.Error())
+		}
+	}
+	p.totalBytes = p.liveBytes
+	return nil
+}
+
+func (p *DiskProvider) compactLoop(stop, done chan bool) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+
+		case <-time.After(p.opts.CompactInterval):
+			p.mtx.RLock()
+			garbage := p.totalBytes - p.liveBytes
+			needCompact := p.totalBytes > 0 && float64(garbage)/float64(p.totalBytes) >= p.opts.CompactRatio
+			p.mtx.RUnlock()
+
+			if needCompact {
+				p.Compact()
+			}
+		}
+	}
+}
+
+func (p *DiskProvider) updateOpts(key string, fn func(*kiva.ItemOptions)) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	entry, ok := p.index[key]
+	if !ok {
+		return errors.New("key not found")
+	}
+	opts := *entry.opts
+	fn(&opts)
+	optsBs, e := json.Marshal(opts)
+	if e != nil {
+		return fmt.Errorf("encode options: %s", e.Error())
+	}
+	return p.write(&record{op: opOpts, key: key, opts: optsBs}, &opts)
+}
+
+// write appends record to active segment and applies it to index, caller should hold the lock
+func (p *DiskProvider) write(rec *record, opts *kiva.ItemOptions) error {
+	if p.segments == nil {
+		return errors.New("provider is not connected")
+	}
+
+	bs := rec.encode()
+	if p.activeSize > 0 && p.activeSize+int64(len(bs)) > p.opts.SegmentSize {
+		if e := p.rotate(); e != nil {
+			return e
+		}
+	}
+	f := p.segments[p.active]
+	if _, e := f.WriteAt(bs, p.activeSize); e != nil {
+		return fmt.Errorf("write: %s", e.Error())
+	}
+	if p.opts.SyncWrites {
+		if e := f.Sync(); e != nil {
+			return fmt.Errorf("sync: %s", e.Error())
+		}
+	}
+	offset := p.activeSize
+	p.activeSize += int64(len(bs))
+	return p.apply(rec, opts, p.active, offset)
+}
+
+// apply changes index based on record, opts is decoded from record when nil
+func (p *DiskProvider) apply(rec *record, opts *kiva.ItemOptions, segment uint32, offset int64) error {
+	size := rec.size()
+	p.totalBytes += size
+
+	if opts == nil && rec.op != opDelete {
+		opts = new(kiva.ItemOptions)
+		if e := json.Unmarshal(rec.opts, opts); e != nil {
+			return fmt.Errorf("decode options: %s", e.Error())
+		}
+	}
+
+	entry, exists := p.index[rec.key]
+	switch rec.op {
+	case opPut:
+		if exists {
+			p.liveBytes -= entry.putBytes + entry.optsBytes
+		} else {
+			p.addKey(rec.key)
+		}
+		p.index[rec.key] = &indexEntry{
+			opts:     opts,
+			segment:  segment,
+			offset:   offset + rec.valueOffset(),
+			size:     len(rec.value),
+			putBytes: size,
+		}
+		p.liveBytes += size
+
+	case opOpts:
+		if !exists {
+			return nil
+		}
+		p.liveBytes += size - entry.optsBytes
+		entry.opts = opts
+		entry.optsBytes = size
+
+	case opDelete:
+		if !exists {
+			return nil
+		}
+		p.liveBytes -= entry.putBytes + entry.optsBytes
+		delete(p.index, rec.key)
+		p.removeKey(rec.key)
+	}
+	return nil
+}
+
+func (p *DiskProvider) replay(id uint32) error {
+	f, e := os.OpenFile(segmentName(p.opts.Dir, id), os.O_RDWR, 0644)
+	if e != nil {
+		return e
+	}
+	p.segments[id] = f
+
+	r := bufio.NewReader(f)
+	offset := int64(0)
+	for {
+		rec, e := readRecord(r)
+		if e == io.EOF {
+			return nil
+		}
+		if e == io.ErrUnexpectedEOF || e == errCorrupted {
+			return f.Truncate(offset)
+		}
+		if e != nil {
+			return e
+		}
+		if e = p.apply(rec, nil, id, offset); e != nil {
+			return e
+		}
+		offset += rec.size()
+	}
+}
+
+func (p *DiskProvider) rotate() error {
+	if e := p.segments[p.active].Sync(); e != nil {
+		return fmt.Errorf("sync: %s", e.Error())
+	}
+	return p.openSegment(p.active + 1)
+}
+
+func (p *DiskProvider) openSegment(id uint32) error {
+	f, e := os.OpenFile(segmentName(p.opts.Dir, id), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if e != nil {
+		return fmt.Errorf("open segment %d: %s", id, e.Error())
+	}
+	p.segments[id] = f
+	p.active = id
+	p.activeSize = 0
+	return nil
+}
+
+func (p *DiskProvider) closeFiles() {
+	for id, f := range p.segments {
+		f.Sync()
+		f.Close()
+		delete(p.segments, id)
+	}
+	p.segments = nil
+}
+
+func (p *DiskProvider) addKey(key string) {
+	index := sort.SearchStrings(p.keys, key)
+	p.keys = append(p.keys, "")
+	copy(p.keys[index+1:], p.keys[index:])
+	p.keys[index] = key
+}
+
+func (p *DiskProvider) removeKey(key string) {
+	index := sort.SearchStrings(p.keys, key)
+	if index < len(p.keys) && p.keys[index] == key {
+		p.keys = append(p.keys[:index], p.keys[index+1:]...)
+	}
+}
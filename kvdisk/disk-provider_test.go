@@ -0,0 +1,136 @@
+package kvdisk_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvdisk"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type record struct {
+	Name string
+	Age  int
+}
+
+func TestDiskProvider(t *testing.T) {
+	convey.Convey("Preparing", t, func() {
+		dir := t.TempDir()
+		opts := &kvdisk.Options{Dir: dir, SegmentSize: 4 << 10, CompactInterval: -1}
+		p := kvdisk.New(opts)
+		convey.So(p.Connect(), convey.ShouldBeNil)
+
+		var e error
+		for i := 0; i < 100; i++ {
+			e = p.Set(fmt.Sprintf("people:P%03d", i), record{Name: fmt.Sprintf("Name %d", i), Age: i},
+				&kiva.WriteOptions{TTL: time.Hour, SyncKind: kiva.SyncBatch})
+			if e != nil {
+				break
+			}
+		}
+		convey.So(e, convey.ShouldBeNil)
+		p.Delete("people:P010")
+		convey.So(p.UpdateLastSyncTime("people:P020"), convey.ShouldBeNil)
+
+		convey.Convey("read", func() {
+			r := record{}
+			opts, e := p.Get("people:P050", &r)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(r.Name, convey.ShouldEqual, "Name 50")
+			convey.So(opts.SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+			convey.So(len(p.Keys("people:P0*")), convey.ShouldEqual, 99)
+			convey.So(p.KeyRanges("people:P009", "people:P012"), convey.ShouldResemble, []string{
+				"people:P009", "people:P011", "people:P012"})
+
+			_, e = p.Get("people:P010", &r)
+			convey.So(e, convey.ShouldEqual, io.EOF)
+			p.Close()
+		})
+
+		convey.Convey("survive restart", func() {
+			p.Close()
+			p = kvdisk.New(opts)
+			convey.So(p.Connect(), convey.ShouldBeNil)
+			defer p.Close()
+
+			convey.So(len(p.Keys("*")), convey.ShouldEqual, 99)
+			convey.So(p.HasKey("people:P010"), convey.ShouldBeFalse)
+			convey.So(p.ItemOpts("people:P020").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+			convey.So(p.ItemOpts("people:P021").SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+
+			r := record{}
+			_, e := p.Get("people:P099", &r)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(r.Age, convey.ShouldEqual, 99)
+		})
+
+		convey.Convey("recover torn tail", func() {
+			p.Close()
+			segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+			last := segments[len(segments)-1]
+			f, _ := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0644)
+			f.Write([]byte{1, 2, 3, 4, 200, 0, 0, 0, 1, 2})
+			f.Close()
+
+			p = kvdisk.New(opts)
+			convey.So(p.Connect(), convey.ShouldBeNil)
+			defer p.Close()
+			convey.So(len(p.Keys("*")), convey.ShouldEqual, 99)
+			convey.So(p.Set("people:P100", record{Name: "After"}, &kiva.WriteOptions{TTL: time.Hour}), convey.ShouldBeNil)
+
+			p.Close()
+			p = kvdisk.New(opts)
+			convey.So(p.Connect(), convey.ShouldBeNil)
+			convey.So(len(p.Keys("*")), convey.ShouldEqual, 100)
+		})
+
+		convey.Convey("compaction", func() {
+			for i := 0; i < 100; i++ {
+				p.Set(fmt.Sprintf("people:P%03d", i), record{Name: fmt.Sprintf("New %d", i), Age: i},
+					&kiva.WriteOptions{TTL: time.Hour})
+			}
+			before, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+			convey.So(p.(*kvdisk.DiskProvider).Compact(), convey.ShouldBeNil)
+			after, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+			convey.So(len(after), convey.ShouldBeLessThan, len(before))
+
+			p.Close()
+			p = kvdisk.New(opts)
+			convey.So(p.Connect(), convey.ShouldBeNil)
+			defer p.Close()
+
+			r := record{}
+			_, e := p.Get("people:P010", &r)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(r.Name, convey.ShouldEqual, "New 10")
+			convey.So(len(p.Keys("*")), convey.ShouldEqual, 100)
+		})
+
+		convey.Convey("codec by table", func() {
+			p.Close()
+			codecOpts := *opts
+			codecOpts.Codecs = kiva.Codecs{Tables: map[string]kiva.Codec{"packed": kiva.MsgpackCodec, "gob": kiva.GobCodec}}
+			p = kvdisk.New(&codecOpts)
+			convey.So(p.Connect(), convey.ShouldBeNil)
+			defer p.Close()
+
+			for _, key := range []string{"packed:A", "gob:A"} {
+				convey.So(p.Set(key, record{Name: "Coded", Age: 7}, &kiva.WriteOptions{TTL: time.Hour}), convey.ShouldBeNil)
+				r := record{}
+				_, e := p.Get(key, &r)
+				convey.So(e, convey.ShouldBeNil)
+				convey.So(r, convey.ShouldResemble, record{Name: "Coded", Age: 7})
+			}
+
+			r := record{}
+			_, e := p.Get("people:P050", &r)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(r.Name, convey.ShouldEqual, "Name 50")
+		})
+	})
+}
@@ -0,0 +1,138 @@
+package kvdisk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentExt     = ".seg"
+	headerSize     = 8
+	maxPayloadSize = 1 << 30
+)
+
+type opKind byte
+
+const (
+	opPut opKind = iota + 1
+	opOpts
+	opDelete
+)
+
+var errCorrupted = errors.New("corrupted record")
+
+// record is one entry of segment log. Layout is
+// crc32(4) | payload length(4) | op(1) | key length(4) | key | opts length(4) | opts | value length(4) | value
+type record struct {
+	op    opKind
+	key   string
+	opts  []byte
+	value []byte
+}
+
+func (r *record) size() int64 {
+	return int64(headerSize + 1 + 4 + len(r.key) + 4 + len(r.opts) + 4 + len(r.value))
+}
+
+// valueOffset returns position of value bytes relative to record start
+func (r *record) valueOffset() int64 {
+	return r.size() - int64(len(r.value))
+}
+
+func (r *record) encode() []byte {
+	buf := make([]byte, r.size())
+	pos := headerSize
+	buf[pos] = byte(r.op)
+	pos++
+	pos = putBytes(buf, pos, []byte(r.key))
+	pos = putBytes(buf, pos, r.opts)
+	putBytes(buf, pos, r.value)
+
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(buf)-headerSize))
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+func putBytes(buf []byte, pos int, data []byte) int {
+	binary.LittleEndian.PutUint32(buf[pos:], uint32(len(data)))
+	pos += 4
+	copy(buf[pos:], data)
+	return pos + len(data)
+}
+
+// readRecord reads next record, io.EOF is returned on clean end of segment while
+// io.ErrUnexpectedEOF and errCorrupted indicate a torn or damaged tail
+func readRecord(r *bufio.Reader) (*record, error) {
+	header := make([]byte, headerSize)
+	if _, e := io.ReadFull(r, header); e != nil {
+		return nil, e
+	}
+	payloadSize := binary.LittleEndian.Uint32(header[4:8])
+	if payloadSize > maxPayloadSize {
+		return nil, errCorrupted
+	}
+	payload := make([]byte, payloadSize)
+	if _, e := io.ReadFull(r, payload); e != nil {
+		if e == io.EOF {
+			e = io.ErrUnexpectedEOF
+		}
+		return nil, e
+	}
+	checksum := crc32.Update(crc32.ChecksumIEEE(header[4:8]), crc32.IEEETable, payload)
+	if checksum != binary.LittleEndian.Uint32(header[0:4]) {
+		return nil, errCorrupted
+	}
+
+	rec := &record{op: opKind(payload[0])}
+	pos := 1
+	parts := make([][]byte, 3)
+	for i := range parts {
+		if pos+4 > len(payload) {
+			return nil, errCorrupted
+		}
+		size := int(binary.LittleEndian.Uint32(payload[pos:]))
+		pos += 4
+		if pos+size > len(payload) {
+			return nil, errCorrupted
+		}
+		parts[i] = payload[pos : pos+size]
+		pos += size
+	}
+	rec.key, rec.opts, rec.value = string(parts[0]), parts[1], parts[2]
+	return rec, nil
+}
+
+func segmentName(dir string, id uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d%s", id, segmentExt))
+}
+
+// listSegments returns ids of segments on dir in ascending order
+func listSegments(dir string) ([]uint32, error) {
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		return nil, e
+	}
+	ids := []uint32{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, e := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 32)
+		if e != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
@@ -0,0 +1,486 @@
+package kvmemcache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sebarcode/kiva"
+)
+
+const (
+	maxKeyLength      = 250
+	maxRelativeExpiry = 30 * 24 * 60 * 60
+	verifyChunkSize   = 100
+	casRetry          = 5
+)
+
+type Options struct {
+	Addr string
+
+	// Prefix is used to namespace all keys written by provider, default is kiva
+	Prefix string
+
+	PoolSize    int
+	DialTimeout time.Duration
+
+	// Timeout is applied to every command when provider context has no deadline
+	Timeout time.Duration
+
+	// Codecs encodes values by table, default is JSON
+	Codecs kiva.Codecs
+}
+
+// MemcacheProvider stores every item as 2 memcached entries, one for the encoded value and
+// a companion one for its ItemOptions. Memcached can not enumerate its keys, hence provider keeps
+// an ordered side index of keys written thru it, index is verified against memcached on Keys and KeyRanges
+type MemcacheProvider struct {
+	opts *Options
+	pool chan *conn
+
+	keys    []string
+	expires map[string]time.Time
+	mtx     *sync.RWMutex
+
+	ctx context.Context
+}
+
+func New(opts *Options) kiva.Provider {
+	p := new(MemcacheProvider)
+	p.opts = new(Options)
+	if opts != nil {
+		*p.opts = *opts
+	}
+	if p.opts.Addr == "" {
+		p.opts.Addr = "127.0.0.1:11211"
+	}
+	if p.opts.Prefix == "" {
+		p.opts.Prefix = "kiva"
+	}
+	if p.opts.PoolSize <= 0 {
+		p.opts.PoolSize = 10
+	}
+	if p.opts.DialTimeout == 0 {
+		p.opts.DialTimeout = 5 * time.Second
+	}
+	if p.opts.Timeout == 0 {
+		p.opts.Timeout = 5 * time.Second
+	}
+	p.pool = make(chan *conn, p.opts.PoolSize)
+	p.keys = []string{}
+	p.expires = make(map[string]time.Time)
+	p.mtx = new(sync.RWMutex)
+	return p
+}
+
+func (p *MemcacheProvider) Connect() error {
+	ctx, cancel := p.opCtx(p.Context())
+	defer cancel()
+
+	c, e := dial(ctx, p.opts.Addr, p.opts.DialTimeout)
+	if e != nil {
+		return e
+	}
+	if _, e = c.version(ctx); e != nil {
+		c.close()
+		return fmt.Errorf("version: %s", e.Error())
+	}
+	p.release(c)
+	return nil
+}
+
+func (p *MemcacheProvider) Close() {
+	for {
+		select {
+		case c := <-p.pool:
+			c.close()
+		default:
+			return
+		}
+	}
+}
+
+func (p *MemcacheProvider) Context() context.Context {
+	if p.ctx == nil {
+		p.ctx = context.Background()
+		return p.ctx
+	}
+	return p.ctx
+}
+
+func (p *MemcacheProvider) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+func (p *MemcacheProvider) Set(key string, value interface{}, opts *kiva.WriteOptions) error {
+	return p.SetCtx(p.Context(), key, value, opts)
+}
+
+func (p *MemcacheProvider) SetCtx(ctx context.Context, key string, value interface{}, opts *kiva.WriteOptions) error {
+	if opts == nil {
+		opts = &kiva.WriteOptions{}
+	}
+
+	bs, e := p.opts.Codecs.Of(key).Encode(value)
+	if e != nil {
+		return fmt.Errorf("encode: %s", e.Error())
+	}
+	itemOpts := kiva.NewItemOptions(opts)
+	optsBs, e := json.Marshal(itemOpts)
+	if e != nil {
+		return fmt.Errorf("encode options: %s", e.Error())
+	}
+
+	exp := exptime(itemOpts)
+	e = p.withConn(ctx, func(ctx context.Context, c *conn) error {
+		if e := c.store(ctx, "set", &cacheItem{key: p.valueKey(key), value: bs}, exp); e != nil {
+			return e
+		}
+		return c.store(ctx, "set", &cacheItem{key: p.optsKey(key), value: optsBs}, exp)
+	})
+	if e != nil {
+		return e
+	}
+
+	p.addIndex(key, itemOpts)
+	return nil
+}
+
+func (p *MemcacheProvider) Get(key string, dest interface{}) (*kiva.ItemOptions, error) {
+	return p.GetCtx(p.Context(), key, dest)
+}
+
+func (p *MemcacheProvider) GetCtx(ctx context.Context, key string, dest interface{}) (*kiva.ItemOptions, error) {
+	valueKey, optsKey := p.valueKey(key), p.optsKey(key)
+
+	var items map[string]*cacheItem
+	e := p.withConn(ctx, func(ctx context.Context, c *conn) error {
+		var e error
+		items, e = c.get(ctx, false, valueKey, optsKey)
+		return e
+	})
+	if e != nil {
+		return nil, e
+	}
+
+	valueItem, hasValue := items[valueKey]
+	optsItem, hasOpts := items[optsKey]
+	if !hasValue || !hasOpts {
+		p.removeIndex(key)
+		return nil, io.EOF
+	}
+
+	if e = p.opts.Codecs.Of(key).Decode(valueItem.value, dest); e != nil {
+		return nil, fmt.Errorf("cast: %s", e.Error())
+	}
+	opts := new(kiva.ItemOptions)
+	if e = json.Unmarshal(optsItem.value, opts); e != nil {
+		return nil, fmt.Errorf("decode options: %s", e.Error())
+	}
+	return opts, nil
+}
+
+func (p *MemcacheProvider) HasKey(key string) bool {
+	return len(p.verify(p.Context(), []string{key})) == 1
+}
+
+func (p *MemcacheProvider) Delete(key string) {
+	p.DeleteCtx(p.Context(), key)
+}
+
+func (p *MemcacheProvider) DeleteCtx(ctx context.Context, key string) {
+	p.withConn(ctx, func(ctx context.Context, c *conn) error {
+		if e := c.delete(ctx, p.valueKey(key)); e != nil && e != errNotFound {
+			return e
+		}
+		if e := c.delete(ctx, p.optsKey(key)); e != nil && e != errNotFound {
+			return e
+		}
+		return nil
+	})
+	p.removeIndex(key)
+}
+
+// Keys follows kvsimple semantic: * returns all keys, otherwise pattern is a key prefix
+func (p *MemcacheProvider) Keys(pattern string) []string {
+	return p.KeysCtx(p.Context(), pattern)
+}
+
+func (p *MemcacheProvider) KeysCtx(ctx context.Context, pattern string) []string {
+	p.mtx.RLock()
+	candidates := []string{}
+	if pattern == "*" {
+		candidates = append(candidates, p.keys...)
+	} else {
+		pattern = strings.TrimSuffix(pattern, "*")
+		for i := sort.SearchStrings(p.keys, pattern); i < len(p.keys) && strings.HasPrefix(p.keys[i], pattern); i++ {
+			candidates = append(candidates, p.keys[i])
+		}
+	}
+	p.mtx.RUnlock()
+
+	return p.verify(ctx, candidates)
+}
+
+func (p *MemcacheProvider) KeyRanges(from string, to string) []string {
+	return p.KeyRangesCtx(p.Context(), from, to)
+}
+
+func (p *MemcacheProvider) KeyRangesCtx(ctx context.Context, from string, to string) []string {
+	p.mtx.RLock()
+	candidates := []string{}
+	for i := sort.SearchStrings(p.keys, from); i < len(p.keys) && strings.Compare(p.keys[i], to) <= 0; i++ {
+		candidates = append(candidates, p.keys[i])
+	}
+	p.mtx.RUnlock()
+
+	return p.verify(ctx, candidates)
+}
+
+func (p *MemcacheProvider) ChangeSyncOpts(key string, opts *kiva.ItemOptions) error {
+	return p.updateOpts(key, func(itemOpts *kiva.ItemOptions) {
+		itemOpts.ApplySync(opts)
+	})
+}
+
+func (p *MemcacheProvider) RenewExpiry(key string) error {
+	var newOpts *kiva.ItemOptions
+	e := p.updateOpts(key, func(itemOpts *kiva.ItemOptions) {
+		itemOpts.Expiry = time.Now().Add(itemOpts.ExpiryExtendDuration)
+		newOpts = itemOpts
+	})
+	if e != nil {
+		return e
+	}
+
+	p.addIndex(key, newOpts)
+	return p.withConn(p.Context(), func(ctx context.Context, c *conn) error {
+		return c.touch(ctx, p.valueKey(key), exptime(newOpts))
+	})
+}
+
+func (p *MemcacheProvider) UpdateLastSyncTime(key string) error {
+	return p.updateOpts(key, func(itemOpts *kiva.ItemOptions) {
+		itemOpts.MarkSynced()
+	})
+}
+
+func (p *MemcacheProvider) ItemOpts(key string) *kiva.ItemOptions {
+	var items map[string]*cacheItem
+	optsKey := p.optsKey(key)
+	e := p.withConn(p.Context(), func(ctx context.Context, c *conn) error {
+		var e error
+		items, e = c.get(ctx, false, optsKey)
+		return e
+	})
+	if e != nil {
+		return nil
+	}
+	item, ok := items[optsKey]
+	if !ok {
+		return nil
+	}
+	opts := new(kiva.ItemOptions)
+	if e = json.Unmarshal(item.value, opts); e != nil {
+		return nil
+	}
+	return opts
+}
+
+// updateOpts changes companion options entry using gets/cas, so concurrent changes are not lost
+func (p *MemcacheProvider) updateOpts(key string, fn func(*kiva.ItemOptions)) error {
+	optsKey := p.optsKey(key)
+	return p.withConn(p.Context(), func(ctx context.Context, c *conn) error {
+		for i := 0; i < casRetry; i++ {
+			items, e := c.get(ctx, true, optsKey)
+			if e != nil {
+				return e
+			}
+			item, ok := items[optsKey]
+			if !ok {
+				return errors.New("key not found")
+			}
+
+			opts := new(kiva.ItemOptions)
+			if e = json.Unmarshal(item.value, opts); e != nil {
+				return fmt.Errorf("decode options: %s", e.Error())
+			}
+			fn(opts)
+			if item.value, e = json.Marshal(opts); e != nil {
+				return fmt.Errorf("encode options: %s", e.Error())
+			}
+
+			e = c.store(ctx, "cas", item, exptime(opts))
+			if e == errCasExists {
+				continue
+			}
+			if e == errNotFound {
+				return errors.New("key not found")
+			}
+			return e
+		}
+		return errCasExists
+	})
+}
+
+// verify returns keys still exist on memcached, others are removed from index
+func (p *MemcacheProvider) verify(ctx context.Context, keys []string) []string {
+	now := time.Now()
+	existingKeys := []string{}
+	missingKeys := []string{}
+
+	p.mtx.RLock()
+	candidates := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if exp := p.expires[key]; !exp.IsZero() && exp.Before(now) {
+			missingKeys = append(missingKeys, key)
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	p.mtx.RUnlock()
+
+	for start := 0; start < len(candidates); start += verifyChunkSize {
+		end := start + verifyChunkSize
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+		chunk := candidates[start:end]
+		cacheKeys := make([]string, len(chunk))
+		for i, key := range chunk {
+			cacheKeys[i] = p.optsKey(key)
+		}
+
+		var items map[string]*cacheItem
+		e := p.withConn(ctx, func(ctx context.Context, c *conn) error {
+			var e error
+			items, e = c.get(ctx, false, cacheKeys...)
+			return e
+		})
+		if e != nil {
+			return existingKeys
+		}
+		for i, key := range chunk {
+			if _, ok := items[cacheKeys[i]]; ok {
+				existingKeys = append(existingKeys, key)
+			} else {
+				missingKeys = append(missingKeys, key)
+			}
+		}
+	}
+
+	for _, key := range missingKeys {
+		p.removeIndex(key)
+	}
+	return existingKeys
+}
+
+func (p *MemcacheProvider) addIndex(key string, opts *kiva.ItemOptions) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if opts.ExpiryExtendDuration > 0 {
+		p.expires[key] = opts.RetainUntil()
+	} else {
+		p.expires[key] = time.Time{}
+	}
+
+	index := sort.SearchStrings(p.keys, key)
+	if index < len(p.keys) && p.keys[index] == key {
+		return
+	}
+	p.keys = append(p.keys, "")
+	copy(p.keys[index+1:], p.keys[index:])
+	p.keys[index] = key
+}
+
+func (p *MemcacheProvider) removeIndex(key string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	delete(p.expires, key)
+	index := sort.SearchStrings(p.keys, key)
+	if index < len(p.keys) && p.keys[index] == key {
+		p.keys = append(p.keys[:index], p.keys[index+1:]...)
+	}
+}
+
+func (p *MemcacheProvider) valueKey(key string) string {
+	return p.cacheKey("v", key)
+}
+
+func (p *MemcacheProvider) optsKey(key string) string {
+	return p.cacheKey("o", key)
+}
+
+// cacheKey builds memcached key, key which is too long or has whitespace or control chars is hashed
+func (p *MemcacheProvider) cacheKey(kind, key string) string {
+	cacheKey := p.opts.Prefix + ":" + kind + ":" + key
+	if len(cacheKey) <= maxKeyLength && strings.IndexFunc(key, func(r rune) bool { return r <= ' ' || r == 0x7f }) < 0 {
+		return cacheKey
+	}
+	sum := sha1.Sum([]byte(key))
+	return p.opts.Prefix + ":" + kind + ":#" + hex.EncodeToString(sum[:])
+}
+
+// opCtx applies Timeout to ctx when it has no deadline yet
+func (p *MemcacheProvider) opCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, hasDeadline := ctx.Deadline(); hasDeadline || p.opts.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.opts.Timeout)
+}
+
+func (p *MemcacheProvider) withConn(ctx context.Context, fn func(ctx context.Context, c *conn) error) error {
+	ctx, cancel := p.opCtx(ctx)
+	defer cancel()
+
+	var c *conn
+	select {
+	case c = <-p.pool:
+	default:
+		var e error
+		if c, e = dial(ctx, p.opts.Addr, p.opts.DialTimeout); e != nil {
+			return e
+		}
+	}
+	defer p.release(c)
+	return fn(ctx, c)
+}
+
+func (p *MemcacheProvider) release(c *conn) {
+	if c.broken {
+		c.close()
+		return
+	}
+	select {
+	case p.pool <- c:
+	default:
+		c.close()
+	}
+}
+
+// exptime converts item expiry into memcached exptime, 0 means never expire
+func exptime(opts *kiva.ItemOptions) int64 {
+	if opts.ExpiryExtendDuration <= 0 {
+		return 0
+	}
+	retainUntil := opts.RetainUntil()
+	remaining := time.Until(retainUntil)
+	if remaining <= 0 {
+		return -1
+	}
+	secs := int64((remaining + time.Second - 1) / time.Second)
+	if secs > maxRelativeExpiry {
+		return retainUntil.Unix()
+	}
+	return secs
+}
@@ -0,0 +1,251 @@
+package kvmemcache_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvmemcache"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type record struct {
+	Name string
+	Age  int
+}
+
+func TestMemcacheProvider(t *testing.T) {
+	convey.Convey("Preparing", t, func() {
+		srv, e := startFakeMemcache()
+		convey.So(e, convey.ShouldBeNil)
+		defer srv.Close()
+
+		p := kvmemcache.New(&kvmemcache.Options{Addr: srv.Addr(), Prefix: "test"})
+		convey.So(p.Connect(), convey.ShouldBeNil)
+		defer p.Close()
+
+		for i := 0; i < 20; i++ {
+			e = p.Set(fmt.Sprintf("people:P%02d", i), record{Name: fmt.Sprintf("Name %d", i), Age: 20 + i},
+				&kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch})
+			if e != nil {
+				break
+			}
+		}
+		convey.So(e, convey.ShouldBeNil)
+
+		convey.Convey("get", func() {
+			r := record{}
+			opts, e := p.Get("people:P05", &r)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(r.Name, convey.ShouldEqual, "Name 5")
+			convey.So(opts.SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+
+			_, e = p.Get("people:X01", &r)
+			convey.So(e, convey.ShouldEqual, io.EOF)
+		})
+
+		convey.Convey("keys and ranges from side index", func() {
+			convey.So(len(p.Keys("*")), convey.ShouldEqual, 20)
+			convey.So(len(p.Keys("people:P1*")), convey.ShouldEqual, 10)
+			convey.So(p.KeyRanges("people:P03", "people:P06"), convey.ShouldResemble, []string{
+				"people:P03", "people:P04", "people:P05", "people:P06"})
+
+			p.Delete("people:P04")
+			convey.So(p.HasKey("people:P04"), convey.ShouldBeFalse)
+			convey.So(len(p.KeyRanges("people:P03", "people:P06")), convey.ShouldEqual, 3)
+
+			convey.Convey("evicted by memcached", func() {
+				srv.Evict("test:o:people:P05")
+				convey.So(p.KeyRanges("people:P03", "people:P06"), convey.ShouldResemble, []string{
+					"people:P03", "people:P06"})
+			})
+		})
+
+		convey.Convey("long key is hashed", func() {
+			longKey := "people:" + strings.Repeat("L", 300)
+			convey.So(p.Set(longKey, record{Name: "Long"}, &kiva.WriteOptions{TTL: time.Minute}), convey.ShouldBeNil)
+			r := record{}
+			_, e := p.Get(longKey, &r)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(r.Name, convey.ShouldEqual, "Long")
+		})
+
+		convey.Convey("sync by kiva", func() {
+			mtx := new(sync.Mutex)
+			committed := map[string]interface{}{}
+			_, e := kiva.New(p, func(string) interface{} { return map[string]interface{}{} },
+				func(key1, key2 string, op kiva.GetKind, dest interface{}) error {
+					return io.EOF
+				},
+				func(key string, value interface{}, op kiva.CommitKind) error {
+					mtx.Lock()
+					defer mtx.Unlock()
+					committed[key] = value
+					return nil
+				},
+				&kiva.KivaOptions{
+					DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+					SyncBatch:    kiva.SyncBatchOptions{EveryInSecond: 1},
+				})
+			convey.So(e, convey.ShouldBeNil)
+
+			time.Sleep(1500 * time.Millisecond)
+			mtx.Lock()
+			convey.So(len(committed), convey.ShouldEqual, 20)
+			mtx.Unlock()
+			convey.So(p.ItemOpts("people:P07").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+		})
+	})
+}
+
+type fakeItem struct {
+	value    []byte
+	cas      uint64
+	expireAt time.Time
+}
+
+// fakeMemcache is an in-process stand-in speaking memcached text protocol, it implements only commands used by MemcacheProvider
+type fakeMemcache struct {
+	ln      net.Listener
+	mtx     sync.Mutex
+	data    map[string]*fakeItem
+	lastCas uint64
+}
+
+func startFakeMemcache() (*fakeMemcache, error) {
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		return nil, e
+	}
+	s := &fakeMemcache{ln: ln, data: map[string]*fakeItem{}}
+	go func() {
+		for {
+			c, e := ln.Accept()
+			if e != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s, nil
+}
+
+func (s *fakeMemcache) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeMemcache) Close() {
+	s.ln.Close()
+}
+
+func (s *fakeMemcache) Evict(key string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.data, key)
+}
+
+func (s *fakeMemcache) item(key string) *fakeItem {
+	it, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !it.expireAt.IsZero() && it.expireAt.Before(time.Now()) {
+		delete(s.data, key)
+		return nil
+	}
+	return it
+}
+
+func (s *fakeMemcache) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	for {
+		line, e := r.ReadString('\n')
+		if e != nil {
+			return
+		}
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+
+		s.mtx.Lock()
+		switch parts[0] {
+		case "version":
+			w.WriteString("VERSION 1.6.0\r\n")
+
+		case "get", "gets":
+			for _, key := range parts[1:] {
+				it := s.item(key)
+				if it == nil {
+					continue
+				}
+				w.WriteString("VALUE " + key + " 0 " + strconv.Itoa(len(it.value)))
+				if parts[0] == "gets" {
+					w.WriteString(" " + strconv.FormatUint(it.cas, 10))
+				}
+				w.WriteString("\r\n")
+				w.Write(it.value)
+				w.WriteString("\r\n")
+			}
+			w.WriteString("END\r\n")
+
+		case "set", "cas":
+			size, _ := strconv.Atoi(parts[4])
+			buf := make([]byte, size+2)
+			if _, e = io.ReadFull(r, buf); e != nil {
+				s.mtx.Unlock()
+				return
+			}
+			exp, _ := strconv.ParseInt(parts[3], 10, 64)
+			current := s.item(parts[1])
+			switch {
+			case parts[0] == "cas" && current == nil:
+				w.WriteString("NOT_FOUND\r\n")
+
+			case parts[0] == "cas" && strconv.FormatUint(current.cas, 10) != parts[5]:
+				w.WriteString("EXISTS\r\n")
+
+			default:
+				s.lastCas++
+				it := &fakeItem{value: buf[:size], cas: s.lastCas}
+				if exp != 0 {
+					it.expireAt = time.Now().Add(time.Duration(exp) * time.Second)
+				}
+				s.data[parts[1]] = it
+				w.WriteString("STORED\r\n")
+			}
+
+		case "delete":
+			if s.item(parts[1]) == nil {
+				w.WriteString("NOT_FOUND\r\n")
+			} else {
+				delete(s.data, parts[1])
+				w.WriteString("DELETED\r\n")
+			}
+
+		case "touch":
+			it := s.item(parts[1])
+			if it == nil {
+				w.WriteString("NOT_FOUND\r\n")
+			} else {
+				exp, _ := strconv.ParseInt(parts[2], 10, 64)
+				it.expireAt = time.Now().Add(time.Duration(exp) * time.Second)
+				w.WriteString("TOUCHED\r\n")
+			}
+
+		default:
+			w.WriteString("ERROR\r\n")
+		}
+		s.mtx.Unlock()
+		w.Flush()
+	}
+}
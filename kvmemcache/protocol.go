@@ -0,0 +1,200 @@
+package kvmemcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errNotStored = errors.New("not stored")
+	errCasExists = errors.New("item has been modified")
+	errNotFound  = errors.New("not found")
+)
+
+type cacheItem struct {
+	key   string
+	value []byte
+	cas   uint64
+}
+
+type conn struct {
+	nc     net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	broken bool
+}
+
+func dial(ctx context.Context, addr string, timeout time.Duration) (*conn, error) {
+	d := net.Dialer{Timeout: timeout}
+	nc, e := d.DialContext(ctx, "tcp", addr)
+	if e != nil {
+		return nil, e
+	}
+	return &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}, nil
+}
+
+func (c *conn) close() {
+	c.nc.Close()
+}
+
+func (c *conn) setDeadline(ctx context.Context) {
+	deadline := time.Time{}
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	}
+	c.nc.SetDeadline(deadline)
+}
+
+func (c *conn) flush() error {
+	if e := c.w.Flush(); e != nil {
+		c.broken = true
+		return e
+	}
+	return nil
+}
+
+func (c *conn) readLine() (string, error) {
+	line, e := c.r.ReadString('\n')
+	if e != nil {
+		c.broken = true
+		return "", e
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if strings.HasPrefix(line, "ERROR") || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", fmt.Errorf("memcache: %s", line)
+	}
+	return line, nil
+}
+
+// store runs set or cas command, cas is only used when command is cas
+func (c *conn) store(ctx context.Context, cmd string, item *cacheItem, exptime int64) error {
+	c.setDeadline(ctx)
+	c.w.WriteString(cmd + " " + item.key + " 0 " + strconv.FormatInt(exptime, 10) + " " + strconv.Itoa(len(item.value)))
+	if cmd == "cas" {
+		c.w.WriteString(" " + strconv.FormatUint(item.cas, 10))
+	}
+	c.w.WriteString("\r\n")
+	c.w.Write(item.value)
+	c.w.WriteString("\r\n")
+	if e := c.flush(); e != nil {
+		return e
+	}
+	return c.storeReply()
+}
+
+func (c *conn) storeReply() error {
+	line, e := c.readLine()
+	if e != nil {
+		return e
+	}
+	switch line {
+	case "STORED":
+		return nil
+	case "NOT_STORED":
+		return errNotStored
+	case "EXISTS":
+		return errCasExists
+	case "NOT_FOUND":
+		return errNotFound
+	}
+	return fmt.Errorf("memcache: unexpected reply %s", line)
+}
+
+// get runs get (or gets when withCas is true) for given keys, missing keys are not returned
+func (c *conn) get(ctx context.Context, withCas bool, keys ...string) (map[string]*cacheItem, error) {
+	c.setDeadline(ctx)
+	cmd := "get"
+	if withCas {
+		cmd = "gets"
+	}
+	c.w.WriteString(cmd + " " + strings.Join(keys, " ") + "\r\n")
+	if e := c.flush(); e != nil {
+		return nil, e
+	}
+
+	items := make(map[string]*cacheItem, len(keys))
+	for {
+		line, e := c.readLine()
+		if e != nil {
+			return nil, e
+		}
+		if line == "END" {
+			return items, nil
+		}
+
+		// VALUE <key> <flags> <bytes> [<cas unique>]
+		parts := strings.Fields(line)
+		if len(parts) < 4 || parts[0] != "VALUE" {
+			c.broken = true
+			return nil, fmt.Errorf("memcache: unexpected reply %s", line)
+		}
+		size, e := strconv.Atoi(parts[3])
+		if e != nil {
+			c.broken = true
+			return nil, fmt.Errorf("memcache: invalid value length: %s", e.Error())
+		}
+		item := &cacheItem{key: parts[1]}
+		if len(parts) > 4 {
+			item.cas, _ = strconv.ParseUint(parts[4], 10, 64)
+		}
+		buf := make([]byte, size+2)
+		if _, e = io.ReadFull(c.r, buf); e != nil {
+			c.broken = true
+			return nil, e
+		}
+		item.value = buf[:size]
+		items[item.key] = item
+	}
+}
+
+func (c *conn) delete(ctx context.Context, key string) error {
+	c.setDeadline(ctx)
+	c.w.WriteString("delete " + key + "\r\n")
+	if e := c.flush(); e != nil {
+		return e
+	}
+	line, e := c.readLine()
+	if e != nil {
+		return e
+	}
+	if line == "NOT_FOUND" {
+		return errNotFound
+	}
+	return nil
+}
+
+func (c *conn) touch(ctx context.Context, key string, exptime int64) error {
+	c.setDeadline(ctx)
+	c.w.WriteString("touch " + key + " " + strconv.FormatInt(exptime, 10) + "\r\n")
+	if e := c.flush(); e != nil {
+		return e
+	}
+	line, e := c.readLine()
+	if e != nil {
+		return e
+	}
+	if line == "NOT_FOUND" {
+		return errNotFound
+	}
+	return nil
+}
+
+func (c *conn) version(ctx context.Context) (string, error) {
+	c.setDeadline(ctx)
+	c.w.WriteString("version\r\n")
+	if e := c.flush(); e != nil {
+		return "", e
+	}
+	line, e := c.readLine()
+	if e != nil {
+		return "", e
+	}
+	return strings.TrimPrefix(line, "VERSION "), nil
+}
@@ -0,0 +1,460 @@
+package kvredis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sebarcode/kiva"
+)
+
+const (
+	fieldValue = "v"
+	fieldOpts  = "o"
+	scanCount  = "1000"
+)
+
+type Options struct {
+	Addr     string
+	Password string
+	DB       int
+
+	// Prefix is used to namespace all keys written by provider, default is kiva
+	Prefix string
+
+	PoolSize    int
+	DialTimeout time.Duration
+
+	// Timeout is applied to every command when provider context has no deadline
+	Timeout time.Duration
+
+	// Codecs encodes values by table, default is JSON
+	Codecs kiva.Codecs
+}
+
+// RedisProvider stores every item as a hash holding the encoded value and its ItemOptions,
+// item TTL is handled by redis and a sorted set is maintained as key index for KeyRanges
+type RedisProvider struct {
+	opts *Options
+	pool chan *conn
+
+	ctx context.Context
+}
+
+func New(opts *Options) kiva.Provider {
+	p := new(RedisProvider)
+	p.opts = new(Options)
+	if opts != nil {
+		*p.opts = *opts
+	}
+	if p.opts.Addr == "" {
+		p.opts.Addr = "127.0.0.1:6379"
+	}
+	if p.opts.Prefix == "" {
+		p.opts.Prefix = "kiva"
+	}
+	if p.opts.PoolSize <= 0 {
+		p.opts.PoolSize = 10
+	}
+	if p.opts.DialTimeout == 0 {
+		p.opts.DialTimeout = 5 * time.Second
+	}
+	if p.opts.Timeout == 0 {
+		p.opts.Timeout = 5 * time.Second
+	}
+	p.pool = make(chan *conn, p.opts.PoolSize)
+	return p
+}
+
+func (p *RedisProvider) Connect() error {
+	ctx, cancel := p.opCtx(p.Context())
+	defer cancel()
+
+	c, e := dial(ctx, p.opts)
+	if e != nil {
+		return e
+	}
+	if _, e = c.do(ctx, "PING"); e != nil {
+		c.close()
+		return fmt.Errorf("ping: %s", e.Error())
+	}
+	p.release(c)
+	return nil
+}
+
+func (p *RedisProvider) Close() {
+	for {
+		select {
+		case c := <-p.pool:
+			c.close()
+		default:
+			return
+		}
+	}
+}
+
+func (p *RedisProvider) Context() context.Context {
+	if p.ctx == nil {
+		p.ctx = context.Background()
+		return p.ctx
+	}
+	return p.ctx
+}
+
+func (p *RedisProvider) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+func (p *RedisProvider) Set(key string, value interface{}, opts *kiva.WriteOptions) error {
+	return p.SetCtx(p.Context(), key, value, opts)
+}
+
+func (p *RedisProvider) SetCtx(ctx context.Context, key string, value interface{}, opts *kiva.WriteOptions) error {
+	if opts == nil {
+		opts = &kiva.WriteOptions{}
+	}
+
+	bs, e := p.opts.Codecs.Of(key).Encode(value)
+	if e != nil {
+		return fmt.Errorf("encode: %s", e.Error())
+	}
+	optsBs, e := json.Marshal(kiva.NewItemOptions(opts))
+	if e != nil {
+		return fmt.Errorf("encode options: %s", e.Error())
+	}
+
+	dataKey := p.dataKey(key)
+	cmds := [][]string{
+		{"MULTI"},
+		{"HSET", dataKey, fieldValue, string(bs), fieldOpts, string(optsBs)},
+	}
+	if opts.TTL > 0 {
+		cmds = append(cmds, []string{"PEXPIRE", dataKey, strconv.FormatInt((opts.TTL + opts.GracePeriod).Milliseconds(), 10)})
+	} else {
+		cmds = append(cmds, []string{"PERSIST", dataKey})
+	}
+	cmds = append(cmds, []string{"ZADD", p.indexKey(), "0", key}, []string{"EXEC"})
+	return p.exec(ctx, cmds)
+}
+
+func (p *RedisProvider) Get(key string, dest interface{}) (*kiva.ItemOptions, error) {
+	return p.GetCtx(p.Context(), key, dest)
+}
+
+func (p *RedisProvider) GetCtx(ctx context.Context, key string, dest interface{}) (*kiva.ItemOptions, error) {
+	reply, e := p.do(ctx, "HMGET", p.dataKey(key), fieldValue, fieldOpts)
+	if e != nil {
+		return nil, e
+	}
+	fields, _ := reply.([]interface{})
+	if len(fields) != 2 || fields[0] == nil || fields[1] == nil {
+		return nil, io.EOF
+	}
+
+	if e = p.opts.Codecs.Of(key).Decode([]byte(fields[0].(string)), dest); e != nil {
+		return nil, fmt.Errorf("cast: %s", e.Error())
+	}
+	opts := new(kiva.ItemOptions)
+	if e = json.Unmarshal([]byte(fields[1].(string)), opts); e != nil {
+		return nil, fmt.Errorf("decode options: %s", e.Error())
+	}
+	return opts, nil
+}
+
+func (p *RedisProvider) HasKey(key string) bool {
+	reply, e := p.do(p.Context(), "EXISTS", p.dataKey(key))
+	if e != nil {
+		return false
+	}
+	return reply.(int64) > 0
+}
+
+func (p *RedisProvider) Delete(key string) {
+	p.DeleteCtx(p.Context(), key)
+}
+
+func (p *RedisProvider) DeleteCtx(ctx context.Context, key string) {
+	p.exec(ctx, [][]string{
+		{"MULTI"},
+		{"DEL", p.dataKey(key)},
+		{"ZREM", p.indexKey(), key},
+		{"EXEC"},
+	})
+}
+
+// Keys scans redis keyspace, pattern follows kvsimple semantic: * returns all keys, otherwise pattern is a key prefix
+func (p *RedisProvider) Keys(pattern string) []string {
+	return p.KeysCtx(p.Context(), pattern)
+}
+
+func (p *RedisProvider) KeysCtx(ctx context.Context, pattern string) []string {
+	match := p.dataKey(escapeGlob(strings.TrimSuffix(pattern, "*")) + "*")
+	keyPrefix := p.dataKey("")
+
+	keys := []string{}
+	cursor := "0"
+	for {
+		reply, e := p.do(ctx, "SCAN", cursor, "MATCH", match, "COUNT", scanCount)
+		if e != nil {
+			break
+		}
+		parts, _ := reply.([]interface{})
+		if len(parts) != 2 {
+			break
+		}
+		cursor, _ = parts[0].(string)
+		found, _ := parts[1].([]interface{})
+		for _, f := range found {
+			keys = append(keys, strings.TrimPrefix(f.(string), keyPrefix))
+		}
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// KeyRanges reads the key index, index members of already expired items are removed on the fly
+func (p *RedisProvider) KeyRanges(from string, to string) []string {
+	return p.KeyRangesCtx(p.Context(), from, to)
+}
+
+func (p *RedisProvider) KeyRangesCtx(ctx context.Context, from string, to string) []string {
+	inRangeKeys := []string{}
+	reply, e := p.do(ctx, "ZRANGEBYLEX", p.indexKey(), "["+from, "["+to)
+	if e != nil {
+		return inRangeKeys
+	}
+	members, _ := reply.([]interface{})
+	if len(members) == 0 {
+		return inRangeKeys
+	}
+
+	cmds := make([][]string, len(members))
+	for i, m := range members {
+		cmds[i] = []string{"EXISTS", p.dataKey(m.(string))}
+	}
+	exists, e := p.pipeline(ctx, cmds)
+	if e != nil {
+		return inRangeKeys
+	}
+
+	staleKeys := []string{p.indexKey()}
+	for i, m := range members {
+		if n, ok := exists[i].(int64); ok && n > 0 {
+			inRangeKeys = append(inRangeKeys, m.(string))
+		} else {
+			staleKeys = append(staleKeys, m.(string))
+		}
+	}
+	if len(staleKeys) > 1 {
+		p.do(ctx, append([]string{"ZREM"}, staleKeys...)...)
+	}
+	return inRangeKeys
+}
+
+func (p *RedisProvider) ChangeSyncOpts(key string, opts *kiva.ItemOptions) error {
+	return p.updateOpts(key, func(itemOpts *kiva.ItemOptions) {
+		itemOpts.ApplySync(opts)
+	})
+}
+
+func (p *RedisProvider) RenewExpiry(key string) error {
+	var ttl, grace time.Duration
+	e := p.updateOpts(key, func(itemOpts *kiva.ItemOptions) {
+		ttl = itemOpts.ExpiryExtendDuration
+		itemOpts.Expiry = time.Now().Add(ttl)
+		grace = itemOpts.GracePeriod
+	})
+	if e != nil || ttl <= 0 {
+		return e
+	}
+	_, e = p.do(p.Context(), "PEXPIRE", p.dataKey(key), strconv.FormatInt((ttl+grace).Milliseconds(), 10))
+	return e
+}
+
+func (p *RedisProvider) UpdateLastSyncTime(key string) error {
+	return p.updateOpts(key, func(itemOpts *kiva.ItemOptions) {
+		itemOpts.MarkSynced()
+	})
+}
+
+func (p *RedisProvider) ItemOpts(key string) *kiva.ItemOptions {
+	opts, e := p.readOpts(key)
+	if e != nil {
+		return nil
+	}
+	return opts
+}
+
+func (p *RedisProvider) readOpts(key string) (*kiva.ItemOptions, error) {
+	return p.decodeOpts(p.do(p.Context(), "HGET", p.dataKey(key), fieldOpts))
+}
+
+// decodeOpts decodes reply of HGET of options field
+func (p *RedisProvider) decodeOpts(reply interface{}, e error) (*kiva.ItemOptions, error) {
+	if e != nil {
+		return nil, e
+	}
+	if reply == nil {
+		return nil, errors.New("key not found")
+	}
+	opts := new(kiva.ItemOptions)
+	if e = json.Unmarshal([]byte(reply.(string)), opts); e != nil {
+		return nil, fmt.Errorf("decode options: %s", e.Error())
+	}
+	return opts, nil
+}
+
+// updateOpts changes options of key in a transaction watching the key, it fails when the key is written
+// (e.g. by SetCtx of another instance) or expires after its options are read. Changed options are not
+// applied to the newer write, which keeps its own sync state, and the hash is never recreated without TTL
+func (p *RedisProvider) updateOpts(key string, fn func(*kiva.ItemOptions)) error {
+	ctx, cancel := p.opCtx(p.Context())
+	defer cancel()
+
+	c, e := p.acquire(ctx)
+	if e != nil {
+		return e
+	}
+	defer p.release(c)
+
+	dataKey := p.dataKey(key)
+	if _, e = c.do(ctx, "WATCH", dataKey); e != nil {
+		return e
+	}
+	opts, e := p.decodeOpts(c.do(ctx, "HGET", dataKey, fieldOpts))
+	if e != nil {
+		c.do(ctx, "UNWATCH")
+		return e
+	}
+	fn(opts)
+	bs, e := json.Marshal(opts)
+	if e != nil {
+		c.do(ctx, "UNWATCH")
+		return fmt.Errorf("encode options: %s", e.Error())
+	}
+
+	replies, e := c.pipeline(ctx, [][]string{{"MULTI"}, {"HSET", dataKey, fieldOpts, string(bs)}, {"EXEC"}})
+	if e != nil {
+		return e
+	}
+	switch r := replies[2].(type) {
+	case nil:
+		return errors.New("key is changed concurrently")
+	case redisError:
+		return r
+	case []interface{}:
+		for _, sub := range r {
+			if re, ok := sub.(redisError); ok {
+				return re
+			}
+		}
+	}
+	return nil
+}
+
+func (p *RedisProvider) dataKey(key string) string {
+	return p.opts.Prefix + ":d:" + key
+}
+
+func (p *RedisProvider) indexKey() string {
+	return p.opts.Prefix + ":idx"
+}
+
+// opCtx applies Timeout to ctx when it has no deadline yet
+func (p *RedisProvider) opCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, hasDeadline := ctx.Deadline(); hasDeadline || p.opts.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.opts.Timeout)
+}
+
+func (p *RedisProvider) acquire(ctx context.Context) (*conn, error) {
+	select {
+	case c := <-p.pool:
+		return c, nil
+	default:
+	}
+	return dial(ctx, p.opts)
+}
+
+func (p *RedisProvider) release(c *conn) {
+	if c.broken {
+		c.close()
+		return
+	}
+	select {
+	case p.pool <- c:
+	default:
+		c.close()
+	}
+}
+
+func (p *RedisProvider) do(ctx context.Context, args ...string) (interface{}, error) {
+	ctx, cancel := p.opCtx(ctx)
+	defer cancel()
+
+	c, e := p.acquire(ctx)
+	if e != nil {
+		return nil, e
+	}
+	defer p.release(c)
+	return c.do(ctx, args...)
+}
+
+func (p *RedisProvider) pipeline(ctx context.Context, cmds [][]string) ([]interface{}, error) {
+	ctx, cancel := p.opCtx(ctx)
+	defer cancel()
+
+	c, e := p.acquire(ctx)
+	if e != nil {
+		return nil, e
+	}
+	defer p.release(c)
+	return c.pipeline(ctx, cmds)
+}
+
+// exec runs commands as one pipeline and returns the first error reply if any,
+// when commands are wrapped by MULTI/EXEC the EXEC reply is checked as well
+func (p *RedisProvider) exec(ctx context.Context, cmds [][]string) error {
+	replies, e := p.pipeline(ctx, cmds)
+	if e != nil {
+		return e
+	}
+	for _, reply := range replies {
+		switch r := reply.(type) {
+		case redisError:
+			return r
+
+		case []interface{}:
+			for _, sub := range r {
+				if re, ok := sub.(redisError); ok {
+					return re
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func escapeGlob(txt string) string {
+	if !strings.ContainsAny(txt, `*?[]\`) {
+		return txt
+	}
+	var b strings.Builder
+	for _, r := range txt {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,462 @@
+package kvredis_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvredis"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type record struct {
+	Name string
+	Age  int
+}
+
+func TestRedisProvider(t *testing.T) {
+	convey.Convey("Preparing", t, func() {
+		srv, e := startFakeRedis()
+		convey.So(e, convey.ShouldBeNil)
+		defer srv.Close()
+
+		p := kvredis.New(&kvredis.Options{Addr: srv.Addr(), Prefix: "test"})
+		convey.So(p.Connect(), convey.ShouldBeNil)
+		defer p.Close()
+
+		for i := 0; i < 20; i++ {
+			e = p.Set(fmt.Sprintf("people:P%02d", i), record{Name: fmt.Sprintf("Name %d", i), Age: 20 + i},
+				&kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch})
+			if e != nil {
+				break
+			}
+		}
+		convey.So(e, convey.ShouldBeNil)
+
+		convey.Convey("get", func() {
+			r := record{}
+			opts, e := p.Get("people:P05", &r)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(r.Name, convey.ShouldEqual, "Name 5")
+			convey.So(r.Age, convey.ShouldEqual, 25)
+			convey.So(opts.SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+			convey.So(opts.SyncKind, convey.ShouldEqual, kiva.SyncBatch)
+
+			_, e = p.Get("people:X01", &r)
+			convey.So(e, convey.ShouldEqual, io.EOF)
+		})
+
+		convey.Convey("keys and ranges", func() {
+			convey.So(len(p.Keys("*")), convey.ShouldEqual, 20)
+			convey.So(p.Keys("people:P1*"), convey.ShouldResemble, []string{
+				"people:P10", "people:P11", "people:P12", "people:P13", "people:P14",
+				"people:P15", "people:P16", "people:P17", "people:P18", "people:P19"})
+			convey.So(p.KeyRanges("people:P03", "people:P06"), convey.ShouldResemble, []string{
+				"people:P03", "people:P04", "people:P05", "people:P06"})
+
+			p.Delete("people:P04")
+			convey.So(p.HasKey("people:P04"), convey.ShouldBeFalse)
+			convey.So(len(p.KeyRanges("people:P03", "people:P06")), convey.ShouldEqual, 3)
+		})
+
+		convey.Convey("sync options", func() {
+			convey.So(p.UpdateLastSyncTime("people:P01"), convey.ShouldBeNil)
+			opts := p.ItemOpts("people:P01")
+			convey.So(opts, convey.ShouldNotBeNil)
+			convey.So(opts.SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+
+			opts.SyncDirection = kiva.SyncToPersistent
+			opts.SyncEveryInSecond = 7
+			convey.So(p.ChangeSyncOpts("people:P01", opts), convey.ShouldBeNil)
+			opts = p.ItemOpts("people:P01")
+			convey.So(opts.SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+			convey.So(opts.SyncEveryInSecond, convey.ShouldEqual, 7)
+
+			convey.So(p.ChangeSyncOpts("people:X01", opts), convey.ShouldNotBeNil)
+		})
+
+		convey.Convey("sync options are not applied over concurrent write", func() {
+			other := kvredis.New(&kvredis.Options{Addr: srv.Addr(), Prefix: "test"})
+			convey.So(other.Connect(), convey.ShouldBeNil)
+			defer other.Close()
+			srv.onExec(func() {
+				other.Set("people:P02", record{Name: "Newer"}, &kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch})
+			})
+			before := p.ItemOpts("people:P02")
+
+			convey.So(p.UpdateLastSyncTime("people:P02"), convey.ShouldNotBeNil)
+			opts := p.ItemOpts("people:P02")
+			convey.So(opts.SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+			convey.So(opts.Version, convey.ShouldNotEqual, before.Version)
+			r := record{}
+			p.Get("people:P02", &r)
+			convey.So(r.Name, convey.ShouldEqual, "Newer")
+		})
+
+		convey.Convey("sync options of key expiring meanwhile are not written", func() {
+			convey.So(p.Set("people:T02", record{Name: "Temp"}, &kiva.WriteOptions{TTL: 30 * time.Millisecond}), convey.ShouldBeNil)
+			srv.onExec(func() {
+				time.Sleep(60 * time.Millisecond)
+			})
+
+			convey.So(p.UpdateLastSyncTime("people:T02"), convey.ShouldNotBeNil)
+			convey.So(p.HasKey("people:T02"), convey.ShouldBeFalse)
+		})
+
+		convey.Convey("native ttl", func() {
+			e := p.Set("people:T01", record{Name: "Temp"}, &kiva.WriteOptions{TTL: 50 * time.Millisecond})
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(p.HasKey("people:T01"), convey.ShouldBeTrue)
+
+			time.Sleep(100 * time.Millisecond)
+			convey.So(p.HasKey("people:T01"), convey.ShouldBeFalse)
+			convey.So(len(p.KeyRanges("people:T00", "people:T99")), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("used by kiva", func() {
+			committed := map[string]interface{}{}
+			kv, e := kiva.New(p, func(string) interface{} { return map[string]interface{}{} },
+				func(key1, key2 string, op kiva.GetKind, dest interface{}) error {
+					return io.EOF
+				},
+				func(key string, value interface{}, op kiva.CommitKind) error {
+					committed[key] = value
+					return nil
+				},
+				&kiva.KivaOptions{DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNow}})
+			convey.So(e, convey.ShouldBeNil)
+
+			e = kv.Set("people:K01", record{Name: "Kiva", Age: 1}, nil, true)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(committed["people:K01"], convey.ShouldNotBeNil)
+
+			res := []record{}
+			e = kv.GetByPattern("people:P0*", &res, false)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(len(res), convey.ShouldEqual, 10)
+			convey.So(res[9].Name, convey.ShouldEqual, "Name 9")
+		})
+
+		convey.Convey("stale while revalidate outlives native ttl", func() {
+			loads := int32(0)
+			kv, e := kiva.New(p, func(string) interface{} { return &record{} },
+				func(key1, key2 string, op kiva.GetKind, dest interface{}) error {
+					r := record{Name: "Stale", Age: int(atomic.AddInt32(&loads, 1))}
+					switch d := dest.(type) {
+					case *record:
+						*d = r
+					case *interface{}:
+						*d = r
+					}
+					return nil
+				}, nil,
+				&kiva.KivaOptions{
+					DefaultWrite: kiva.WriteOptions{TTL: 50 * time.Millisecond, SyncKind: kiva.SyncNone},
+					Refresh:      kiva.RefreshOptions{StaleWhileRevalidate: true},
+				})
+			convey.So(e, convey.ShouldBeNil)
+
+			res := record{}
+			convey.So(kv.Get("people:S01", &res), convey.ShouldBeNil)
+			convey.So(res.Age, convey.ShouldEqual, 1)
+
+			time.Sleep(75 * time.Millisecond)
+			res = record{}
+			convey.So(kv.Get("people:S01", &res), convey.ShouldBeNil)
+			convey.So(res.Age, convey.ShouldEqual, 1)
+
+			time.Sleep(25 * time.Millisecond)
+			res = record{}
+			convey.So(kv.Get("people:S01", &res), convey.ShouldBeNil)
+			convey.So(res.Age, convey.ShouldEqual, 2)
+		})
+	})
+}
+
+type fakeEntry struct {
+	hash     map[string]string
+	zset     map[string]bool
+	expireAt time.Time
+}
+
+// fakeRedis is an in-process stand-in speaking RESP, it implements only commands used by RedisProvider
+type fakeRedis struct {
+	ln   net.Listener
+	mtx  sync.Mutex
+	data map[string]*fakeEntry
+
+	// versions counts changes of each key for WATCH, expiry is a change too
+	versions map[string]int64
+	// beforeExec, when set, runs before EXEC of a transaction watching keys
+	beforeExec func()
+}
+
+func startFakeRedis() (*fakeRedis, error) {
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		return nil, e
+	}
+	s := &fakeRedis{ln: ln, data: map[string]*fakeEntry{}, versions: map[string]int64{}}
+	go func() {
+		for {
+			c, e := ln.Accept()
+			if e != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s, nil
+}
+
+// onExec runs fn once before EXEC of next transaction watching keys
+func (s *fakeRedis) onExec(fn func()) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	once := new(sync.Once)
+	s.beforeExec = func() { once.Do(fn) }
+}
+
+func (s *fakeRedis) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeRedis) Close() {
+	s.ln.Close()
+}
+
+func (s *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	var queued [][]string
+	inMulti := false
+	watched := map[string]int64{}
+	for {
+		args, e := readCommand(r)
+		if e != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "MULTI":
+			inMulti = true
+			queued = nil
+			w.WriteString("+OK\r\n")
+
+		case cmd == "WATCH":
+			s.mtx.Lock()
+			for _, key := range args[1:] {
+				s.entry(key, false)
+				watched[key] = s.versions[key]
+			}
+			s.mtx.Unlock()
+			w.WriteString("+OK\r\n")
+
+		case cmd == "UNWATCH":
+			watched = map[string]int64{}
+			w.WriteString("+OK\r\n")
+
+		case cmd == "EXEC":
+			s.mtx.Lock()
+			beforeExec := s.beforeExec
+			s.mtx.Unlock()
+			if len(watched) > 0 && beforeExec != nil {
+				beforeExec()
+			}
+			s.mtx.Lock()
+			aborted := false
+			for key, version := range watched {
+				s.entry(key, false)
+				aborted = aborted || s.versions[key] != version
+			}
+			if aborted {
+				w.WriteString("*-1\r\n")
+			} else {
+				w.WriteString("*" + strconv.Itoa(len(queued)) + "\r\n")
+				for _, q := range queued {
+					w.WriteString(s.runLocked(q))
+				}
+			}
+			s.mtx.Unlock()
+			inMulti = false
+			watched = map[string]int64{}
+
+		case inMulti:
+			queued = append(queued, args)
+			w.WriteString("+QUEUED\r\n")
+
+		default:
+			w.WriteString(s.run(args))
+		}
+		w.Flush()
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, e := r.ReadString('\n')
+	if e != nil {
+		return nil, e
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, e = r.ReadString('\n')
+		if e != nil {
+			return nil, e
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, e = io.ReadFull(r, buf); e != nil {
+			return nil, e
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (s *fakeRedis) entry(key string, create bool) *fakeEntry {
+	en, ok := s.data[key]
+	if ok && !en.expireAt.IsZero() && en.expireAt.Before(time.Now()) {
+		delete(s.data, key)
+		s.versions[key]++
+		en, ok = nil, false
+	}
+	if !ok && create {
+		en = &fakeEntry{hash: map[string]string{}, zset: map[string]bool{}}
+		s.data[key] = en
+	}
+	return en
+}
+
+func (s *fakeRedis) run(args []string) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.runLocked(args)
+}
+
+func (s *fakeRedis) runLocked(args []string) string {
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "HSET", "PEXPIRE", "PERSIST", "DEL", "ZADD", "ZREM":
+		s.versions[args[1]]++
+	}
+
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+
+	case "HSET":
+		en := s.entry(args[1], true)
+		for i := 2; i+1 < len(args); i += 2 {
+			en.hash[args[i]] = args[i+1]
+		}
+		return ":1\r\n"
+
+	case "HGET":
+		en := s.entry(args[1], false)
+		if en == nil {
+			return "$-1\r\n"
+		}
+		v, ok := en.hash[args[2]]
+		return bulk(v, ok)
+
+	case "HMGET":
+		en := s.entry(args[1], false)
+		res := "*" + strconv.Itoa(len(args)-2) + "\r\n"
+		for _, f := range args[2:] {
+			if en == nil {
+				res += "$-1\r\n"
+				continue
+			}
+			v, ok := en.hash[f]
+			res += bulk(v, ok)
+		}
+		return res
+
+	case "PEXPIRE":
+		en := s.entry(args[1], false)
+		if en == nil {
+			return ":0\r\n"
+		}
+		ms, _ := strconv.Atoi(args[2])
+		en.expireAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+
+	case "PERSIST":
+		if en := s.entry(args[1], false); en != nil {
+			en.expireAt = time.Time{}
+		}
+		return ":1\r\n"
+
+	case "DEL":
+		delete(s.data, args[1])
+		return ":1\r\n"
+
+	case "EXISTS":
+		if s.entry(args[1], false) == nil {
+			return ":0\r\n"
+		}
+		return ":1\r\n"
+
+	case "ZADD":
+		s.entry(args[1], true).zset[args[3]] = true
+		return ":1\r\n"
+
+	case "ZREM":
+		if en := s.entry(args[1], false); en != nil {
+			for _, m := range args[2:] {
+				delete(en.zset, m)
+			}
+		}
+		return ":1\r\n"
+
+	case "ZRANGEBYLEX":
+		members := []string{}
+		if en := s.entry(args[1], false); en != nil {
+			from, to := args[2][1:], args[3][1:]
+			for m := range en.zset {
+				if m >= from && m <= to {
+					members = append(members, m)
+				}
+			}
+		}
+		sort.Strings(members)
+		return array(members)
+
+	case "SCAN":
+		keys := []string{}
+		for k := range s.data {
+			if ok, _ := path.Match(args[3], k); ok && s.entry(k, false) != nil {
+				keys = append(keys, k)
+			}
+		}
+		return "*2\r\n" + bulk("0", true) + array(keys)
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func bulk(v string, ok bool) string {
+	if !ok {
+		return "$-1\r\n"
+	}
+	return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+}
+
+func array(items []string) string {
+	res := "*" + strconv.Itoa(len(items)) + "\r\n"
+	for _, item := range items {
+		res += bulk(item, true)
+	}
+	return res
+}
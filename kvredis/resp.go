@@ -0,0 +1,166 @@
+package kvredis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisError is an error reply (-ERR ...) sent by the server
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+type conn struct {
+	nc     net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	broken bool
+}
+
+func dial(ctx context.Context, opts *Options) (*conn, error) {
+	d := net.Dialer{Timeout: opts.DialTimeout}
+	nc, e := d.DialContext(ctx, "tcp", opts.Addr)
+	if e != nil {
+		return nil, e
+	}
+	c := &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	if opts.Password != "" {
+		if _, e = c.do(ctx, "AUTH", opts.Password); e != nil {
+			c.close()
+			return nil, fmt.Errorf("auth: %s", e.Error())
+		}
+	}
+	if opts.DB != 0 {
+		if _, e = c.do(ctx, "SELECT", strconv.Itoa(opts.DB)); e != nil {
+			c.close()
+			return nil, fmt.Errorf("select db: %s", e.Error())
+		}
+	}
+	return c, nil
+}
+
+func (c *conn) close() {
+	c.nc.Close()
+}
+
+// do sends one command and reads its reply
+func (c *conn) do(ctx context.Context, args ...string) (interface{}, error) {
+	replies, e := c.pipeline(ctx, [][]string{args})
+	if e != nil {
+		return nil, e
+	}
+	if re, ok := replies[0].(redisError); ok {
+		return nil, re
+	}
+	return replies[0], nil
+}
+
+// pipeline sends all commands in one write then reads their replies in order.
+// Error replies are returned as redisError inside the result, only network and protocol errors are returned as error
+func (c *conn) pipeline(ctx context.Context, cmds [][]string) ([]interface{}, error) {
+	deadline := time.Time{}
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	}
+	c.nc.SetDeadline(deadline)
+
+	for _, args := range cmds {
+		c.writeCommand(args)
+	}
+	if e := c.w.Flush(); e != nil {
+		c.broken = true
+		return nil, e
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, e := c.readReply()
+		if e != nil {
+			c.broken = true
+			return nil, e
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+func (c *conn) writeCommand(args []string) {
+	c.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		c.w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		c.w.WriteString(arg)
+		c.w.WriteString("\r\n")
+	}
+}
+
+func (c *conn) readLine() (string, error) {
+	line, e := c.r.ReadString('\n')
+	if e != nil {
+		return "", e
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", errors.New("resp: invalid line")
+	}
+	return line[:len(line)-2], nil
+}
+
+// readReply returns string for simple and bulk string, int64 for integer, nil for null,
+// []interface{} for array and redisError for error reply
+func (c *conn) readReply() (interface{}, error) {
+	line, e := c.readLine()
+	if e != nil {
+		return nil, e
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+
+	case '-':
+		return redisError(line[1:]), nil
+
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+
+	case '$':
+		size, e := strconv.Atoi(line[1:])
+		if e != nil {
+			return nil, fmt.Errorf("resp: invalid bulk length: %s", e.Error())
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, e = io.ReadFull(c.r, buf); e != nil {
+			return nil, e
+		}
+		return string(buf[:size]), nil
+
+	case '*':
+		size, e := strconv.Atoi(line[1:])
+		if e != nil {
+			return nil, fmt.Errorf("resp: invalid array length: %s", e.Error())
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, size)
+		for i := range items {
+			if items[i], e = c.readReply(); e != nil {
+				return nil, e
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("resp: unknown reply type %q", line[0])
+}
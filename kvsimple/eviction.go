@@ -0,0 +1,170 @@
+package kvsimple
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// EvictionPolicy decides which key is removed when SimpleProvider exceeds its capacity.
+// Policy is always called by provider under its write lock
+type EvictionPolicy interface {
+	// Add is called when a new key is stored
+	Add(key string)
+
+	// Access is called when an existing key is read or overwritten
+	Access(key string)
+
+	// Remove is called when key is deleted or evicted
+	Remove(key string)
+
+	// Victim returns key to be evicted, only key accepted by canEvict can be returned
+	Victim(canEvict func(key string) bool) (string, bool)
+}
+
+type lruPolicy struct {
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewLRU returns policy evicting least recently used key
+func NewLRU() EvictionPolicy {
+	return &lruPolicy{ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) Add(key string) {
+	if el, ok := p.items[key]; ok {
+		p.ll.MoveToFront(el)
+		return
+	}
+	p.items[key] = p.ll.PushFront(key)
+}
+
+func (p *lruPolicy) Access(key string) {
+	if el, ok := p.items[key]; ok {
+		p.ll.MoveToFront(el)
+	}
+}
+
+func (p *lruPolicy) Remove(key string) {
+	if el, ok := p.items[key]; ok {
+		p.ll.Remove(el)
+		delete(p.items, key)
+	}
+}
+
+func (p *lruPolicy) Victim(canEvict func(key string) bool) (string, bool) {
+	return lastEvictable(p.ll, canEvict)
+}
+
+type lfuItem struct {
+	key   string
+	freq  int
+	seq   uint64
+	index int
+}
+
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq == h[j].freq {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].freq < h[j].freq
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	item := x.(*lfuItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+type lfuPolicy struct {
+	h     lfuHeap
+	items map[string]*lfuItem
+	seq   uint64
+}
+
+// NewLFU returns policy evicting least frequently used key, ties are broken by recency
+func NewLFU() EvictionPolicy {
+	return &lfuPolicy{items: make(map[string]*lfuItem)}
+}
+
+func (p *lfuPolicy) Add(key string) {
+	if _, ok := p.items[key]; ok {
+		p.Access(key)
+		return
+	}
+	p.seq++
+	item := &lfuItem{key: key, freq: 1, seq: p.seq}
+	p.items[key] = item
+	heap.Push(&p.h, item)
+}
+
+func (p *lfuPolicy) Access(key string) {
+	item, ok := p.items[key]
+	if !ok {
+		return
+	}
+	p.seq++
+	item.freq++
+	item.seq = p.seq
+	heap.Fix(&p.h, item.index)
+}
+
+func (p *lfuPolicy) Remove(key string) {
+	item, ok := p.items[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&p.h, item.index)
+	delete(p.items, key)
+}
+
+func (p *lfuPolicy) Victim(canEvict func(key string) bool) (string, bool) {
+	skipped := []*lfuItem{}
+	defer func() {
+		for _, item := range skipped {
+			heap.Push(&p.h, item)
+		}
+	}()
+
+	for p.h.Len() > 0 {
+		item := heap.Pop(&p.h).(*lfuItem)
+		skipped = append(skipped, item)
+		if canEvict(item.key) {
+			return item.key, true
+		}
+	}
+	return "", false
+}
+
+// lastEvictable walks list from its back (least recent) and returns first evictable key
+func lastEvictable(ll *list.List, canEvict func(key string) bool) (string, bool) {
+	for el := ll.Back(); el != nil; el = el.Prev() {
+		if key := elementKey(el); canEvict(key) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func elementKey(el *list.Element) string {
+	switch v := el.Value.(type) {
+	case string:
+		return v
+	case *tinyLFUEntry:
+		return v.key
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+package kvsimple_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+var writeOpts = &kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch}
+
+// setSynced writes key and marks it as committed, so it becomes evictable
+func setSynced(p kiva.Provider, key string, value interface{}) {
+	p.Set(key, value, writeOpts)
+	p.UpdateLastSyncTime(key)
+}
+
+func TestEviction(t *testing.T) {
+	convey.Convey("LRU", t, func() {
+		p := kvsimple.NewWithOptions(&kvsimple.Options{MaxItems: 3})
+		setSynced(p, "t:a", 1)
+		setSynced(p, "t:b", 2)
+		setSynced(p, "t:c", 3)
+		v := 0
+		p.Get("t:a", &v)
+		setSynced(p, "t:d", 4)
+
+		convey.So(p.HasKey("t:b"), convey.ShouldBeFalse)
+		convey.So(p.Keys("*"), convey.ShouldResemble, []string{"t:a", "t:c", "t:d"})
+	})
+
+	convey.Convey("LFU", t, func() {
+		p := kvsimple.NewWithOptions(&kvsimple.Options{MaxItems: 3, Policy: kvsimple.NewLFU()})
+		setSynced(p, "t:a", 1)
+		setSynced(p, "t:b", 2)
+		setSynced(p, "t:c", 3)
+		v := 0
+		for i := 0; i < 3; i++ {
+			p.Get("t:a", &v)
+			p.Get("t:c", &v)
+		}
+		p.Get("t:b", &v)
+		setSynced(p, "t:d", 4)
+
+		convey.So(p.HasKey("t:b"), convey.ShouldBeFalse)
+		convey.So(len(p.Keys("*")), convey.ShouldEqual, 3)
+	})
+
+	convey.Convey("W-TinyLFU keeps frequently used keys on scan", t, func() {
+		p := kvsimple.NewWithOptions(&kvsimple.Options{MaxItems: 100, Policy: kvsimple.NewWTinyLFU(100)})
+		v := 0
+		for i := 0; i < 10; i++ {
+			setSynced(p, fmt.Sprintf("hot:%d", i), i)
+		}
+		for n := 0; n < 5; n++ {
+			for i := 0; i < 10; i++ {
+				p.Get(fmt.Sprintf("hot:%d", i), &v)
+			}
+		}
+		for i := 0; i < 1000; i++ {
+			setSynced(p, fmt.Sprintf("scan:%d", i), i)
+		}
+
+		hotKeys, scanKeys := 0, 0
+		for i := 0; i < 1000; i++ {
+			if i < 10 && p.HasKey(fmt.Sprintf("hot:%d", i)) {
+				hotKeys++
+			}
+			if p.HasKey(fmt.Sprintf("scan:%d", i)) {
+				scanKeys++
+			}
+		}
+		convey.So(hotKeys, convey.ShouldEqual, 10)
+		convey.So(hotKeys+scanKeys, convey.ShouldEqual, 100)
+	})
+
+	convey.Convey("items not yet committed are not evicted", t, func() {
+		p := kvsimple.NewWithOptions(&kvsimple.Options{MaxItems: 2})
+		p.Set("t:a", 1, writeOpts)
+		p.Set("t:b", 2, writeOpts)
+		p.Set("t:c", 3, writeOpts)
+		convey.So(len(p.Keys("*")), convey.ShouldEqual, 3)
+
+		convey.Convey("evicted once committed", func() {
+			p.UpdateLastSyncTime("t:b")
+			convey.So(p.Keys("*"), convey.ShouldResemble, []string{"t:a", "t:c"})
+		})
+	})
+
+	convey.Convey("items without sync are evicted right away", t, func() {
+		p := kvsimple.NewWithOptions(&kvsimple.Options{MaxItems: 10})
+		for i := 0; i < 1000; i++ {
+			p.Set(fmt.Sprintf("t:%d", i), i, &kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNone})
+		}
+		convey.So(len(p.Keys("*")), convey.ShouldEqual, 10)
+		convey.So(p.HasKey("t:999"), convey.ShouldBeTrue)
+
+		convey.Convey("item with empty SyncKind is still waiting for commit", func() {
+			p.Set("t:pending", 1, &kiva.WriteOptions{TTL: time.Minute})
+			for i := 1000; i < 1020; i++ {
+				p.Set(fmt.Sprintf("t:%d", i), i, &kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNone})
+			}
+			convey.So(p.HasKey("t:pending"), convey.ShouldBeTrue)
+		})
+	})
+
+	convey.Convey("limit by bytes", t, func() {
+		p := kvsimple.NewWithOptions(&kvsimple.Options{MaxBytes: 1000})
+		for i := 0; i < 10; i++ {
+			setSynced(p, fmt.Sprintf("t:%d", i), strings.Repeat("x", 200))
+		}
+		convey.So(len(p.Keys("*")), convey.ShouldBeLessThanOrEqualTo, 4)
+		convey.So(p.HasKey("t:9"), convey.ShouldBeTrue)
+	})
+}
@@ -0,0 +1,125 @@
+package kvsimple
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	skipMaxLevel    = 32
+	skipProbability = 0.25
+)
+
+type skipNode struct {
+	key  string
+	next []*skipNode
+}
+
+// keyIndex is a skiplist keeping keys in ascending order, insert and remove are O(log n)
+// and range lookup seeks the first key then scans forward. It is not safe for concurrent use
+type keyIndex struct {
+	head   *skipNode
+	level  int
+	length int
+	rnd    *rand.Rand
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		head:  &skipNode{next: make([]*skipNode, skipMaxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (s *keyIndex) randomLevel() int {
+	level := 1
+	for level < skipMaxLevel && s.rnd.Float64() < skipProbability {
+		level++
+	}
+	return level
+}
+
+// findPrev fills update with the last node of each level whose key is less than key
+func (s *keyIndex) findPrev(key string, update []*skipNode) *skipNode {
+	node := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		if update != nil {
+			update[i] = node
+		}
+	}
+	return node.next[0]
+}
+
+// insert adds key, it returns false when key already exists
+func (s *keyIndex) insert(key string) bool {
+	update := make([]*skipNode, skipMaxLevel)
+	if next := s.findPrev(key, update); next != nil && next.key == key {
+		return false
+	}
+
+	level := s.randomLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = s.head
+		}
+		s.level = level
+	}
+	node := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	s.length++
+	return true
+}
+
+// remove deletes key, it returns false when key does not exist
+func (s *keyIndex) remove(key string) bool {
+	update := make([]*skipNode, skipMaxLevel)
+	node := s.findPrev(key, update)
+	if node == nil || node.key != key {
+		return false
+	}
+	for i := 0; i < len(node.next); i++ {
+		update[i].next[i] = node.next[i]
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.length--
+	return true
+}
+
+// seek returns first node having key greater or equal than given key
+func (s *keyIndex) seek(key string) *skipNode {
+	return s.findPrev(key, nil)
+}
+
+func (s *keyIndex) all() []string {
+	keys := make([]string, 0, s.length)
+	for node := s.head.next[0]; node != nil; node = node.next[0] {
+		keys = append(keys, node.key)
+	}
+	return keys
+}
+
+func (s *keyIndex) prefix(prefix string) []string {
+	keys := []string{}
+	for node := s.seek(prefix); node != nil && strings.HasPrefix(node.key, prefix); node = node.next[0] {
+		keys = append(keys, node.key)
+	}
+	return keys
+}
+
+func (s *keyIndex) between(from, to string) []string {
+	keys := []string{}
+	for node := s.seek(from); node != nil && node.key <= to; node = node.next[0] {
+		keys = append(keys, node.key)
+	}
+	return keys
+}
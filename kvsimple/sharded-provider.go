@@ -0,0 +1,166 @@
+package kvsimple
+
+import (
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"runtime"
+
+	"github.com/sebarcode/kiva"
+)
+
+// ShardedProvider spreads keys over several SimpleProvider by key hash, every shard has its own lock
+// so operations on different keys rarely contend. Keys and KeyRanges merge ordered result of all shards
+type ShardedProvider struct {
+	shards []*SimpleProvider
+	ctx    context.Context
+}
+
+// NewSharded creates provider with given number of shards, default is 4 shards per CPU.
+// MaxItems and MaxBytes of opts are divided evenly among shards and every shard gets its own policy
+// from opts.NewPolicy (LRU when not set), opts.Policy is ignored
+func NewSharded(shardCount int, opts *Options) kiva.Provider {
+	if shardCount <= 0 {
+		shardCount = runtime.GOMAXPROCS(0) * 4
+	}
+
+	shardOpts := Options{}
+	if opts != nil {
+		shardOpts = *opts
+	}
+	shardOpts.Policy = nil
+	if shardOpts.MaxItems > 0 {
+		shardOpts.MaxItems = (shardOpts.MaxItems + shardCount - 1) / shardCount
+	}
+	if shardOpts.MaxBytes > 0 {
+		shardOpts.MaxBytes = (shardOpts.MaxBytes + int64(shardCount) - 1) / int64(shardCount)
+	}
+
+	p := new(ShardedProvider)
+	p.shards = make([]*SimpleProvider, shardCount)
+	for i := range p.shards {
+		shardOpts := shardOpts
+		p.shards[i] = NewWithOptions(&shardOpts).(*SimpleProvider)
+	}
+	return p
+}
+
+func (p *ShardedProvider) shard(key string) *SimpleProvider {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return p.shards[h.Sum32()%uint32(len(p.shards))]
+}
+
+func (p *ShardedProvider) Connect() error {
+	return nil
+}
+
+func (p *ShardedProvider) Close() {
+}
+
+func (p *ShardedProvider) Context() context.Context {
+	if p.ctx == nil {
+		p.ctx = context.Background()
+		return p.ctx
+	}
+	return p.ctx
+}
+
+func (p *ShardedProvider) SetContext(ctx context.Context) {
+	p.ctx = ctx
+	for _, s := range p.shards {
+		s.SetContext(ctx)
+	}
+}
+
+func (p *ShardedProvider) Set(key string, value interface{}, opts *kiva.WriteOptions) error {
+	return p.shard(key).Set(key, value, opts)
+}
+
+func (p *ShardedProvider) Get(key string, dest interface{}) (*kiva.ItemOptions, error) {
+	return p.shard(key).Get(key, dest)
+}
+
+func (p *ShardedProvider) HasKey(key string) bool {
+	return p.shard(key).HasKey(key)
+}
+
+func (p *ShardedProvider) Delete(key string) {
+	p.shard(key).Delete(key)
+}
+
+func (p *ShardedProvider) Keys(pattern string) []string {
+	parts := make([][]string, len(p.shards))
+	for i, s := range p.shards {
+		parts[i] = s.Keys(pattern)
+	}
+	return mergeSorted(parts)
+}
+
+func (p *ShardedProvider) KeyRanges(from string, to string) []string {
+	parts := make([][]string, len(p.shards))
+	for i, s := range p.shards {
+		parts[i] = s.KeyRanges(from, to)
+	}
+	return mergeSorted(parts)
+}
+
+func (p *ShardedProvider) ChangeSyncOpts(key string, opts *kiva.ItemOptions) error {
+	return p.shard(key).ChangeSyncOpts(key, opts)
+}
+
+func (p *ShardedProvider) RenewExpiry(key string) error {
+	return p.shard(key).RenewExpiry(key)
+}
+
+func (p *ShardedProvider) UpdateLastSyncTime(key string) error {
+	return p.shard(key).UpdateLastSyncTime(key)
+}
+
+func (p *ShardedProvider) ItemOpts(key string) *kiva.ItemOptions {
+	return p.shard(key).ItemOpts(key)
+}
+
+type mergeCursor struct {
+	keys []string
+	pos  int
+}
+
+type mergeHeap []*mergeCursor
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i].keys[h[i].pos] < h[j].keys[h[j].pos] }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeCursor)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// mergeSorted merges sorted slices into one sorted slice
+func mergeSorted(parts [][]string) []string {
+	total := 0
+	h := make(mergeHeap, 0, len(parts))
+	for _, part := range parts {
+		if len(part) > 0 {
+			total += len(part)
+			h = append(h, &mergeCursor{keys: part})
+		}
+	}
+	heap.Init(&h)
+
+	keys := make([]string, 0, total)
+	for h.Len() > 0 {
+		c := h[0]
+		keys = append(keys, c.keys[c.pos])
+		c.pos++
+		if c.pos == len(c.keys) {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return keys
+}
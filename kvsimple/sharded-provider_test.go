@@ -0,0 +1,115 @@
+package kvsimple_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestSharded(t *testing.T) {
+	convey.Convey("keys are merged in order", t, func() {
+		p := kvsimple.NewSharded(8, nil)
+		for _, i := range rand.Perm(500) {
+			p.Set(fmt.Sprintf("t:K%04d", i), i, writeOpts)
+		}
+
+		keys := p.Keys("*")
+		convey.So(len(keys), convey.ShouldEqual, 500)
+		convey.So(keys[0], convey.ShouldEqual, "t:K0000")
+		convey.So(keys[499], convey.ShouldEqual, "t:K0499")
+		convey.So(p.KeyRanges("t:K0100", "t:K0103"), convey.ShouldResemble, []string{
+			"t:K0100", "t:K0101", "t:K0102", "t:K0103"})
+		convey.So(len(p.Keys("t:K02*")), convey.ShouldEqual, 100)
+
+		v := 0
+		_, e := p.Get("t:K0321", &v)
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(v, convey.ShouldEqual, 321)
+	})
+
+	convey.Convey("capacity is split among shards", t, func() {
+		p := kvsimple.NewSharded(4, &kvsimple.Options{MaxItems: 100})
+		for i := 0; i < 1000; i++ {
+			setSynced(p, fmt.Sprintf("t:K%04d", i), i)
+		}
+		convey.So(len(p.Keys("*")), convey.ShouldBeLessThanOrEqualTo, 100)
+	})
+}
+
+// TestConcurrentAccess is meant to be run with -race
+func TestConcurrentAccess(t *testing.T) {
+	providers := map[string]kiva.Provider{
+		"simple":         kvsimple.New(),
+		"simple-bounded": kvsimple.NewWithOptions(&kvsimple.Options{MaxItems: 200, NewPolicy: kvsimple.NewWTinyLFU}),
+		"sharded":        kvsimple.NewSharded(8, &kvsimple.Options{MaxItems: 200}),
+	}
+	for name, p := range providers {
+		t.Run(name, func(t *testing.T) {
+			wg := new(sync.WaitGroup)
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					rnd := rand.New(rand.NewSource(int64(w)))
+					for i := 0; i < 2000; i++ {
+						key := fmt.Sprintf("t:K%04d", rnd.Intn(500))
+						switch rnd.Intn(8) {
+						case 0:
+							p.Delete(key)
+						case 1:
+							p.Keys("t:K01*")
+						case 2:
+							p.KeyRanges("t:K0100", "t:K0200")
+						case 3:
+							if opts := p.ItemOpts(key); opts != nil {
+								opts.SyncEveryInSecond = w
+								p.ChangeSyncOpts(key, opts)
+							}
+						case 4:
+							p.UpdateLastSyncTime(key)
+						case 5:
+							v := 0
+							p.Get(key, &v)
+						default:
+							p.Set(key, i, writeOpts)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkParallelMixed(b *testing.B) {
+	providers := map[string]func() kiva.Provider{
+		"simple":  kvsimple.New,
+		"sharded": func() kiva.Provider { return kvsimple.NewSharded(0, nil) },
+	}
+	for _, name := range []string{"simple", "sharded"} {
+		b.Run(name, func(b *testing.B) {
+			p := providers[name]()
+			for i := 0; i < 10000; i++ {
+				p.Set(fmt.Sprintf("bench:K%05d", i), i, writeOpts)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(rand.Int63()))
+				v := 0
+				for pb.Next() {
+					key := fmt.Sprintf("bench:K%05d", rnd.Intn(10000))
+					if rnd.Intn(4) == 0 {
+						p.Set(key, 1, writeOpts)
+					} else {
+						p.Get(key, &v)
+					}
+				}
+			})
+		})
+	}
+}
@@ -18,22 +18,62 @@ import (
 type providerItem struct {
 	data interface{}
 	opts *kiva.ItemOptions
+	size int64
+}
+
+// Options limits memory used by SimpleProvider, zero value means unlimited.
+// Items still waiting to be committed (SyncToPersistent with SyncKind other than SyncNone) are never evicted,
+// hence the limit may be exceeded temporarily until those items are synced
+type Options struct {
+	MaxItems int
+	MaxBytes int64
+
+	// Policy decides which item is evicted, default is LRU
+	Policy EvictionPolicy
+
+	// NewPolicy creates policy for given capacity in items, it is used when Policy is nil
+	// and by sharded provider which needs one policy per shard
+	NewPolicy func(capacity int) EvictionPolicy
+
+	// SizeOf estimates bytes used by a value, default is EstimateSize
+	SizeOf func(value interface{}) int64
 }
 
 type SimpleProvider struct {
 	defaultWriteOptions *kiva.WriteOptions
-	keys                []string
+	keys                *keyIndex
 	data                map[string]*providerItem
 
+	opts      Options
+	dataBytes int64
+
 	mtx *sync.RWMutex
 	ctx context.Context
 }
 
 func New() kiva.Provider {
+	return NewWithOptions(nil)
+}
+
+func NewWithOptions(opts *Options) kiva.Provider {
 	s := new(SimpleProvider)
 	s.data = make(map[string]*providerItem)
-	s.keys = []string{}
+	s.keys = newKeyIndex()
 	s.mtx = new(sync.RWMutex)
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.MaxItems > 0 || s.opts.MaxBytes > 0 {
+		if s.opts.Policy == nil && s.opts.NewPolicy != nil {
+			s.opts.Policy = s.opts.NewPolicy(s.opts.MaxItems)
+		}
+		if s.opts.Policy == nil {
+			s.opts.Policy = NewLRU()
+		}
+		if s.opts.SizeOf == nil {
+			s.opts.SizeOf = EstimateSize
+		}
+	}
 	return s
 }
 
@@ -66,69 +106,62 @@ func (p *SimpleProvider) Set(key string, value interface{}, opts *kiva.WriteOpti
 	if opts == nil {
 		opts = p.defaultWriteOptions
 	}
+	if opts == nil {
+		opts = &kiva.WriteOptions{}
+	}
 
 	item := providerItem{
 		data: value,
-		opts: &kiva.ItemOptions{
-			Expiry:               time.Now().Add(opts.TTL),
-			SyncDirection:        kiva.SyncToPersistent,
-			ExpiryKind:           opts.ExpiryKind,
-			ExpiryExtendDuration: opts.TTL,
-			SyncKind:             opts.SyncKind,
-			SyncEveryInSecond:    opts.SyncEveryInSecond,
-			LastSync:             time.Now(),
-		},
+		opts: kiva.NewItemOptions(opts),
 	}
 
-	p.data[key] = &item
-
-	found := false
-	alreadyExist := false
-	strCompare := -2
-	cutOffIndex := -1
-	for index, simpleKey := range p.keys {
-		strCompare = strings.Compare(key, simpleKey)
-		if strCompare < 0 {
-			cutOffIndex = index
-			found = true
-			break
-		} else if strCompare == 0 {
-			alreadyExist = true
-			break
+	if p.opts.Policy != nil {
+		item.size = int64(len(key)) + p.opts.SizeOf(value)
+		if old, exists := p.data[key]; exists {
+			p.dataBytes -= old.size
+			p.opts.Policy.Access(key)
+		} else {
+			p.opts.Policy.Add(key)
 		}
+		p.dataBytes += item.size
+		defer p.evict()
 	}
-	if alreadyExist {
-		return nil
-	}
-	if found {
-		var newKeys []string
-		if cutOffIndex > 0 {
-			newKeys = p.keys[:cutOffIndex]
-		}
-		newKeys = append(newKeys, key)
-		if cutOffIndex < len(p.keys)-1 {
-			newKeys = append(newKeys, p.keys[cutOffIndex:]...)
-		}
-		p.keys = newKeys
-	} else {
-		p.keys = append(p.keys, key)
-	}
+
+	p.data[key] = &item
+
+	p.keys.insert(key)
 	return nil
 }
 
 func (p *SimpleProvider) Get(key string, dest interface{}) (*kiva.ItemOptions, error) {
+	// policy keeps access order, so reading also changes provider state
+	if p.opts.Policy != nil {
+		p.mtx.Lock()
+		defer p.mtx.Unlock()
+	} else {
+		p.mtx.RLock()
+		defer p.mtx.RUnlock()
+	}
+
 	v, ok := p.data[key]
 	if !ok {
 		return nil, io.EOF
 	}
+	if p.opts.Policy != nil {
+		p.opts.Policy.Access(key)
+	}
 	e := serde.Serde(v.data, dest)
 	if e != nil {
 		return nil, fmt.Errorf("cast: %s", e.Error())
 	}
-	return v.opts, nil
+	opts := *v.opts
+	return &opts, nil
 }
 
 func (p *SimpleProvider) HasKey(key string) bool {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
 	_, ok := p.data[key]
 	return ok
 }
@@ -137,84 +170,112 @@ func (p *SimpleProvider) Delete(key string) {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 
-	delete(p.data, key)
-	keys := []string{}
-	for _, k := range p.keys {
-		if k != key {
-			keys = append(keys, k)
-		}
+	p.remove(key)
+}
+
+// remove deletes item and its key, caller should hold the lock
+func (p *SimpleProvider) remove(key string) {
+	if item, ok := p.data[key]; ok && p.opts.Policy != nil {
+		p.dataBytes -= item.size
+		p.opts.Policy.Remove(key)
 	}
-	p.keys = keys
+	delete(p.data, key)
+	p.keys.remove(key)
 }
 
 func (p *SimpleProvider) Keys(pattern string) []string {
-	keys := []string{}
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
 	if pattern == "*" {
-		return p.keys
+		return p.keys.all()
 	}
-	pattern = strings.TrimSuffix(pattern, "*")
-	for _, k := range p.keys {
-		if strings.HasPrefix(k, pattern) {
-			keys = append(keys, k)
-		}
-	}
-	return keys
+	return p.keys.prefix(strings.TrimSuffix(pattern, "*"))
 }
 
 func (p *SimpleProvider) KeyRanges(from string, to string) []string {
-	inRangeKeys := []string{}
-	for _, key := range p.keys {
-		if strings.Compare(key, from) >= 0 && strings.Compare(key, to) <= 0 {
-			inRangeKeys = append(inRangeKeys, key)
-		}
-	}
-	return inRangeKeys
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	return p.keys.between(from, to)
 }
 
 func (p *SimpleProvider) ChangeSyncOpts(key string, opts *kiva.ItemOptions) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
 	item, hasItem := p.data[key]
 	if !hasItem {
 		return errors.New("ket not found")
 	}
 
-	item.opts.SyncDirection = opts.SyncDirection
-	item.opts.SyncKind = opts.SyncKind
-	item.opts.SyncEveryInSecond = opts.SyncEveryInSecond
-	p.data[key] = item
-
+	item.opts.ApplySync(opts)
+	if p.opts.Policy != nil {
+		p.evict()
+	}
 	return nil
 }
 
 func (p *SimpleProvider) RenewExpiry(key string) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
 	item, hasItem := p.data[key]
 	if !hasItem {
 		return errors.New("ket not found")
 	}
 
 	item.opts.Expiry = time.Now().Add(item.opts.ExpiryExtendDuration)
-	p.data[key] = item
-
 	return nil
 }
 
 func (p *SimpleProvider) UpdateLastSyncTime(key string) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
 	item, hasItem := p.data[key]
 	if !hasItem {
 		return errors.New("ket not found")
 	}
 
-	item.opts.LastSync = time.Now()
-	item.opts.SyncDirection = kiva.SyncToHots
-	p.data[key] = item
-
+	item.opts.MarkSynced()
+	if p.opts.Policy != nil {
+		p.evict()
+	}
 	return nil
 }
 
+// evict removes items chosen by policy until provider is back within its limits, caller should hold the lock
+func (p *SimpleProvider) evict() {
+	for p.overLimit() {
+		key, ok := p.opts.Policy.Victim(p.canEvict)
+		if !ok {
+			return
+		}
+		p.remove(key)
+	}
+}
+
+func (p *SimpleProvider) overLimit() bool {
+	return (p.opts.MaxItems > 0 && len(p.data) > p.opts.MaxItems) ||
+		(p.opts.MaxBytes > 0 && p.dataBytes > p.opts.MaxBytes)
+}
+
+// canEvict prevents eviction of item which has not been committed to persistent storage
+func (p *SimpleProvider) canEvict(key string) bool {
+	item, ok := p.data[key]
+	return ok && !item.opts.Pending()
+}
+
 func (p *SimpleProvider) ItemOpts(key string) *kiva.ItemOptions {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
 	item, hasItem := p.data[key]
 	if !hasItem {
 		return nil
 	}
 
-	return item.opts
+	opts := *item.opts
+	return &opts
 }
@@ -0,0 +1,116 @@
+package kvsimple_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+var benchSizes = []int{10000, 50000}
+
+func TestKeyIndex(t *testing.T) {
+	convey.Convey("keys are kept ordered", t, func() {
+		p := kvsimple.New()
+		perm := rand.Perm(1000)
+		for _, i := range perm {
+			p.Set(fmt.Sprintf("t:K%04d", i), i, writeOpts)
+		}
+		p.Set("t:K0500", 500, writeOpts)
+
+		keys := p.Keys("*")
+		convey.So(len(keys), convey.ShouldEqual, 1000)
+		for i, key := range keys {
+			if key != fmt.Sprintf("t:K%04d", i) {
+				convey.So(key, convey.ShouldEqual, fmt.Sprintf("t:K%04d", i))
+				break
+			}
+		}
+
+		convey.Convey("prefix and range", func() {
+			convey.So(len(p.Keys("t:K01*")), convey.ShouldEqual, 100)
+			convey.So(p.Keys("t:K099"), convey.ShouldResemble, []string{
+				"t:K0990", "t:K0991", "t:K0992", "t:K0993", "t:K0994",
+				"t:K0995", "t:K0996", "t:K0997", "t:K0998", "t:K0999"})
+			convey.So(p.KeyRanges("t:K0100", "t:K0104"), convey.ShouldResemble, []string{
+				"t:K0100", "t:K0101", "t:K0102", "t:K0103", "t:K0104"})
+			convey.So(len(p.KeyRanges("t:K1000", "t:K2000")), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("delete", func() {
+			for i := 0; i < 1000; i += 2 {
+				p.Delete(fmt.Sprintf("t:K%04d", i))
+			}
+			p.Delete("t:NotExist")
+			convey.So(len(p.Keys("*")), convey.ShouldEqual, 500)
+			convey.So(p.KeyRanges("t:K0100", "t:K0104"), convey.ShouldResemble, []string{
+				"t:K0101", "t:K0103"})
+		})
+	})
+}
+
+func prefilled(n int) (kiva.Provider, []string) {
+	p := kvsimple.New()
+	keys := make([]string, n)
+	for i, v := range rand.Perm(n) {
+		keys[i] = fmt.Sprintf("bench:K%08d", v)
+		p.Set(keys[i], v, writeOpts)
+	}
+	return p, keys
+}
+
+func BenchmarkSet(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			p, _ := prefilled(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.Set(fmt.Sprintf("bench:N%08d", rand.Intn(n)), i, writeOpts)
+			}
+		})
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			p, keys := prefilled(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				key := keys[i%n]
+				p.Delete(key)
+				b.StopTimer()
+				p.Set(key, i, writeOpts)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkKeyRanges(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			p, _ := prefilled(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				from := rand.Intn(n - 100)
+				p.KeyRanges(fmt.Sprintf("bench:K%08d", from), fmt.Sprintf("bench:K%08d", from+99))
+			}
+		})
+	}
+}
+
+func BenchmarkKeysPrefix(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			p, _ := prefilled(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.Keys(fmt.Sprintf("bench:K%06d*", rand.Intn(n/100)))
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package kvsimple
+
+import (
+	"reflect"
+)
+
+const maxSizeDepth = 8
+
+// EstimateSize returns rough number of bytes hold by value, it is the default SizeOf of Options
+func EstimateSize(value interface{}) int64 {
+	if value == nil {
+		return 0
+	}
+	return estimateValue(reflect.ValueOf(value), 0)
+}
+
+func estimateValue(rv reflect.Value, depth int) int64 {
+	if depth > maxSizeDepth {
+		return 0
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return 8
+		}
+		return 8 + estimateValue(rv.Elem(), depth+1)
+
+	case reflect.String:
+		return 16 + int64(rv.Len())
+
+	case reflect.Slice, reflect.Array:
+		size := int64(24)
+		if rv.Kind() == reflect.Array {
+			size = 0
+		}
+		elemKind := rv.Type().Elem().Kind()
+		if elemKind <= reflect.Complex128 {
+			return size + int64(rv.Len())*int64(rv.Type().Elem().Size())
+		}
+		for i := 0; i < rv.Len(); i++ {
+			size += estimateValue(rv.Index(i), depth+1)
+		}
+		return size
+
+	case reflect.Map:
+		size := int64(48)
+		iter := rv.MapRange()
+		for iter.Next() {
+			size += estimateValue(iter.Key(), depth+1) + estimateValue(iter.Value(), depth+1)
+		}
+		return size
+
+	case reflect.Struct:
+		size := int64(0)
+		for i := 0; i < rv.NumField(); i++ {
+			size += estimateValue(rv.Field(i), depth+1)
+		}
+		return size
+	}
+
+	return int64(rv.Type().Size())
+}
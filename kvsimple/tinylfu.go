@@ -0,0 +1,208 @@
+package kvsimple
+
+import (
+	"container/list"
+	"hash/fnv"
+)
+
+const (
+	segmentWindow = iota
+	segmentProbation
+	segmentProtected
+)
+
+type tinyLFUEntry struct {
+	key     string
+	segment int
+}
+
+// wTinyLFU is W-TinyLFU policy: new keys land on a small LRU window, main space is a segmented LRU
+// (probation and protected). When window overflows its least recent key competes with main space victim
+// and the one with lower estimated frequency, taken from a count-min sketch, is evicted
+type wTinyLFU struct {
+	window    *list.List
+	probation *list.List
+	protected *list.List
+	items     map[string]*list.Element
+
+	windowMax    int
+	mainMax      int
+	protectedMax int
+	sketch       *countMinSketch
+}
+
+// NewWTinyLFU returns W-TinyLFU policy, capacity is expected number of items and is used to size its segments
+func NewWTinyLFU(capacity int) EvictionPolicy {
+	if capacity < 1 {
+		capacity = 1000
+	}
+	windowMax := capacity / 100
+	if windowMax < 1 {
+		windowMax = 1
+	}
+	return &wTinyLFU{
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		items:        make(map[string]*list.Element),
+		windowMax:    windowMax,
+		mainMax:      capacity - windowMax,
+		protectedMax: (capacity - windowMax) * 8 / 10,
+		sketch:       newCountMinSketch(capacity),
+	}
+}
+
+func (p *wTinyLFU) Add(key string) {
+	p.sketch.increment(key)
+	if _, ok := p.items[key]; ok {
+		p.Access(key)
+		return
+	}
+	p.items[key] = p.window.PushFront(&tinyLFUEntry{key: key, segment: segmentWindow})
+
+	// while main space is not full, window overflow is moved there without admission contest
+	for p.window.Len() > p.windowMax && p.probation.Len()+p.protected.Len() < p.mainMax {
+		entry := p.window.Remove(p.window.Back()).(*tinyLFUEntry)
+		entry.segment = segmentProbation
+		p.items[entry.key] = p.probation.PushFront(entry)
+	}
+}
+
+func (p *wTinyLFU) Access(key string) {
+	el, ok := p.items[key]
+	if !ok {
+		return
+	}
+	p.sketch.increment(key)
+
+	entry := el.Value.(*tinyLFUEntry)
+	switch entry.segment {
+	case segmentWindow:
+		p.window.MoveToFront(el)
+
+	case segmentProbation:
+		p.probation.Remove(el)
+		entry.segment = segmentProtected
+		p.items[key] = p.protected.PushFront(entry)
+		if p.protected.Len() > p.protectedMax {
+			demoted := p.protected.Remove(p.protected.Back()).(*tinyLFUEntry)
+			demoted.segment = segmentProbation
+			p.items[demoted.key] = p.probation.PushFront(demoted)
+		}
+
+	case segmentProtected:
+		p.protected.MoveToFront(el)
+	}
+}
+
+func (p *wTinyLFU) Remove(key string) {
+	el, ok := p.items[key]
+	if !ok {
+		return
+	}
+	p.segmentList(el.Value.(*tinyLFUEntry).segment).Remove(el)
+	delete(p.items, key)
+}
+
+func (p *wTinyLFU) Victim(canEvict func(key string) bool) (string, bool) {
+	victim, hasVictim := lastEvictable(p.probation, canEvict)
+	if !hasVictim {
+		victim, hasVictim = lastEvictable(p.protected, canEvict)
+	}
+
+	if p.window.Len() > p.windowMax {
+		if candidate, ok := lastEvictable(p.window, canEvict); ok {
+			if !hasVictim || p.sketch.estimate(candidate) <= p.sketch.estimate(victim) {
+				return candidate, true
+			}
+
+			// candidate wins admission, it moves to main space and main victim is evicted
+			el := p.items[candidate]
+			entry := p.window.Remove(el).(*tinyLFUEntry)
+			entry.segment = segmentProbation
+			p.items[candidate] = p.probation.PushFront(entry)
+			return victim, true
+		}
+	}
+
+	if hasVictim {
+		return victim, true
+	}
+	return lastEvictable(p.window, canEvict)
+}
+
+func (p *wTinyLFU) segmentList(segment int) *list.List {
+	switch segment {
+	case segmentProbation:
+		return p.probation
+	case segmentProtected:
+		return p.protected
+	}
+	return p.window
+}
+
+const (
+	sketchDepth      = 4
+	sketchMaxCounter = 15
+)
+
+// countMinSketch estimates access frequency with 4 rows of saturating counters,
+// all counters are halved periodically so old popularity fades away
+type countMinSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	resetAfter int
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 64
+	for width < capacity {
+		width <<= 1
+	}
+	s := &countMinSketch{mask: uint64(width - 1), resetAfter: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *countMinSketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	lo, hi := sum&0xffffffff, sum>>32
+
+	var idx [sketchDepth]uint64
+	for i := range idx {
+		idx[i] = (lo + uint64(i)*hi) & s.mask
+	}
+	return idx
+}
+
+func (s *countMinSketch) increment(key string) {
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < sketchMaxCounter {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAfter {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	min := uint8(sketchMaxCounter)
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < min {
+			min = s.rows[i][idx]
+		}
+	}
+	return min
+}
@@ -0,0 +1,227 @@
+package kvtiered
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ariefdarmawan/serde"
+	"github.com/sebarcode/kiva"
+)
+
+type Options struct {
+	// L1TTL caps lifetime of an item on L1, zero means item lives on L1 as long as on L2
+	L1TTL time.Duration
+}
+
+// tieredEntry is what stored on L1, it carries a snapshot of L2 item options so
+// reading from L1 returns the same options as L2 would
+type tieredEntry struct {
+	Data interface{}
+	Opts kiva.ItemOptions
+}
+
+type expiryRenewer interface {
+	RenewExpiry(key string) error
+}
+
+// TieredProvider composes a small and fast L1 provider (normally process memory) in front of a shared L2 provider.
+// Reads go to L1 then L2 and L2 hits are promoted to L1, writes go thru both tiers. L2 is the owner of items state,
+// sync options are always changed on L2 first then copied to L1. L1 items may be stale for at most L1TTL
+// when L2 is changed by other process
+type TieredProvider struct {
+	l1   kiva.Provider
+	l2   kiva.Provider
+	opts Options
+
+	ctx context.Context
+}
+
+func New(l1, l2 kiva.Provider, opts *Options) kiva.Provider {
+	p := new(TieredProvider)
+	p.l1 = l1
+	p.l2 = l2
+	if opts != nil {
+		p.opts = *opts
+	}
+	return p
+}
+
+func (p *TieredProvider) Connect() error {
+	if e := p.l1.Connect(); e != nil {
+		return fmt.Errorf("l1: %s", e.Error())
+	}
+	if e := p.l2.Connect(); e != nil {
+		p.l1.Close()
+		return fmt.Errorf("l2: %s", e.Error())
+	}
+	return nil
+}
+
+func (p *TieredProvider) Close() {
+	p.l1.Close()
+	p.l2.Close()
+}
+
+func (p *TieredProvider) Context() context.Context {
+	if p.ctx == nil {
+		p.ctx = context.Background()
+		return p.ctx
+	}
+	return p.ctx
+}
+
+func (p *TieredProvider) SetContext(ctx context.Context) {
+	p.ctx = ctx
+	p.l1.SetContext(ctx)
+	p.l2.SetContext(ctx)
+}
+
+func (p *TieredProvider) Set(key string, value interface{}, opts *kiva.WriteOptions) error {
+	return p.SetCtx(p.Context(), key, value, opts)
+}
+
+func (p *TieredProvider) SetCtx(ctx context.Context, key string, value interface{}, opts *kiva.WriteOptions) error {
+	if e := kiva.ProviderSet(ctx, p.l2, key, value, opts); e != nil {
+		return e
+	}
+
+	l2Opts := p.l2.ItemOpts(key)
+	if l2Opts == nil {
+		p.l1.Delete(key)
+		return nil
+	}
+	if reflect.ValueOf(value).Kind() == reflect.Ptr {
+		value = reflect.Indirect(reflect.ValueOf(value)).Interface()
+	}
+	p.promote(key, value, l2Opts, 0)
+	return nil
+}
+
+func (p *TieredProvider) Get(key string, dest interface{}) (*kiva.ItemOptions, error) {
+	return p.GetCtx(p.Context(), key, dest)
+}
+
+func (p *TieredProvider) GetCtx(ctx context.Context, key string, dest interface{}) (*kiva.ItemOptions, error) {
+	entry := tieredEntry{}
+	if l1Opts, e := p.l1.Get(key, &entry); e == nil {
+		if l1Opts.Expiry.After(time.Now()) {
+			if e = serde.Serde(entry.Data, dest); e == nil {
+				opts := entry.Opts
+				return &opts, nil
+			}
+		}
+		p.l1.Delete(key)
+	}
+
+	opts, e := kiva.ProviderGet(ctx, p.l2, key, dest)
+	if e != nil {
+		return nil, e
+	}
+	p.promote(key, reflect.Indirect(reflect.ValueOf(dest)).Interface(), opts, 0)
+	return opts, nil
+}
+
+func (p *TieredProvider) HasKey(key string) bool {
+	return p.l1.HasKey(key) || p.l2.HasKey(key)
+}
+
+func (p *TieredProvider) Delete(key string) {
+	p.DeleteCtx(p.Context(), key)
+}
+
+func (p *TieredProvider) DeleteCtx(ctx context.Context, key string) {
+	kiva.ProviderDelete(ctx, p.l2, key)
+	p.l1.Delete(key)
+}
+
+func (p *TieredProvider) Keys(pattern string) []string {
+	return p.KeysCtx(p.Context(), pattern)
+}
+
+func (p *TieredProvider) KeysCtx(ctx context.Context, pattern string) []string {
+	return kiva.ProviderKeys(ctx, p.l2, pattern)
+}
+
+func (p *TieredProvider) KeyRanges(from string, to string) []string {
+	return p.KeyRangesCtx(p.Context(), from, to)
+}
+
+func (p *TieredProvider) KeyRangesCtx(ctx context.Context, from string, to string) []string {
+	return kiva.ProviderKeyRanges(ctx, p.l2, from, to)
+}
+
+func (p *TieredProvider) ChangeSyncOpts(key string, opts *kiva.ItemOptions) error {
+	if e := p.l2.ChangeSyncOpts(key, opts); e != nil {
+		return e
+	}
+	p.refreshL1(key)
+	return nil
+}
+
+func (p *TieredProvider) RenewExpiry(key string) error {
+	if r, ok := p.l2.(expiryRenewer); ok {
+		if e := r.RenewExpiry(key); e != nil {
+			return e
+		}
+	}
+	p.refreshL1(key)
+	return nil
+}
+
+func (p *TieredProvider) UpdateLastSyncTime(key string) error {
+	if e := p.l2.UpdateLastSyncTime(key); e != nil {
+		return e
+	}
+	p.refreshL1(key)
+	return nil
+}
+
+func (p *TieredProvider) ItemOpts(key string) *kiva.ItemOptions {
+	return p.l2.ItemOpts(key)
+}
+
+// promote writes value into L1 along with L2 options, maxTTL further limits L1 lifetime when not zero
+func (p *TieredProvider) promote(key string, value interface{}, l2Opts *kiva.ItemOptions, maxTTL time.Duration) {
+	ttl := time.Until(l2Opts.RetainUntil())
+	if p.opts.L1TTL > 0 && p.opts.L1TTL < ttl {
+		ttl = p.opts.L1TTL
+	}
+	if maxTTL > 0 && maxTTL < ttl {
+		ttl = maxTTL
+	}
+	if ttl <= 0 {
+		p.l1.Delete(key)
+		return
+	}
+
+	entry := tieredEntry{Data: value, Opts: *l2Opts}
+	if e := p.l1.Set(key, entry, &kiva.WriteOptions{TTL: ttl, ExpiryKind: kiva.ExpiryAbsolute}); e != nil {
+		p.l1.Delete(key)
+		return
+	}
+
+	// L2 owns sync state, L1 copy is always clean so L1 capacity policy is free to evict it
+	p.l1.UpdateLastSyncTime(key)
+}
+
+// refreshL1 copies current L2 options into L1 entry, L1 remaining lifetime is kept
+func (p *TieredProvider) refreshL1(key string) {
+	entry := tieredEntry{}
+	l1Opts, e := p.l1.Get(key, &entry)
+	if e != nil {
+		return
+	}
+	l2Opts := p.l2.ItemOpts(key)
+	if l2Opts == nil {
+		p.l1.Delete(key)
+		return
+	}
+	remaining := time.Until(l1Opts.Expiry)
+	if remaining <= 0 {
+		p.l1.Delete(key)
+		return
+	}
+	p.promote(key, entry.Data, l2Opts, remaining)
+}
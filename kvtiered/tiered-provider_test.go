@@ -0,0 +1,88 @@
+package kvtiered_test
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/sebarcode/kiva/kvtiered"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type record struct {
+	Name string
+	Age  int
+}
+
+func TestTieredProvider(t *testing.T) {
+	convey.Convey("Preparing", t, func() {
+		l1 := kvsimple.NewWithOptions(&kvsimple.Options{MaxItems: 5})
+		l2 := kvsimple.New()
+		p := kvtiered.New(l1, l2, &kvtiered.Options{L1TTL: 200 * time.Millisecond})
+		convey.So(p.Connect(), convey.ShouldBeNil)
+
+		e := p.Set("people:P01", record{Name: "Satu", Age: 1}, &kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch})
+		convey.So(e, convey.ShouldBeNil)
+
+		convey.Convey("write thru both tiers", func() {
+			convey.So(l1.HasKey("people:P01"), convey.ShouldBeTrue)
+			convey.So(l2.HasKey("people:P01"), convey.ShouldBeTrue)
+
+			r := record{}
+			opts, e := p.Get("people:P01", &r)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(r.Name, convey.ShouldEqual, "Satu")
+			convey.So(opts.SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+			convey.So(opts.Expiry.After(time.Now().Add(50*time.Second)), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("L1 has shorter TTL", func() {
+			time.Sleep(300 * time.Millisecond)
+			l2.Set("people:P01", record{Name: "Changed"}, &kiva.WriteOptions{TTL: time.Minute})
+
+			r := record{}
+			_, e := p.Get("people:P01", &r)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(r.Name, convey.ShouldEqual, "Changed")
+		})
+
+		convey.Convey("promotion from L2", func() {
+			l2.Set("people:P02", record{Name: "Dua"}, &kiva.WriteOptions{TTL: time.Minute})
+			convey.So(l1.HasKey("people:P02"), convey.ShouldBeFalse)
+
+			r := record{}
+			_, e := p.Get("people:P02", &r)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(r.Name, convey.ShouldEqual, "Dua")
+			convey.So(l1.HasKey("people:P02"), convey.ShouldBeTrue)
+
+			_, e = p.Get("people:P03", &r)
+			convey.So(e, convey.ShouldEqual, io.EOF)
+		})
+
+		convey.Convey("sync options stay coherent", func() {
+			convey.So(p.UpdateLastSyncTime("people:P01"), convey.ShouldBeNil)
+			r := record{}
+			opts, _ := p.Get("people:P01", &r)
+			convey.So(opts.SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+			convey.So(l2.ItemOpts("people:P01").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+		})
+
+		convey.Convey("L1 capacity", func() {
+			for i := 10; i < 30; i++ {
+				p.Set(fmt.Sprintf("people:P%02d", i), record{Age: i}, &kiva.WriteOptions{TTL: time.Minute})
+			}
+			convey.So(len(l1.Keys("*")), convey.ShouldBeLessThanOrEqualTo, 5)
+			convey.So(len(p.Keys("*")), convey.ShouldEqual, 21)
+		})
+
+		convey.Convey("delete both tiers", func() {
+			p.Delete("people:P01")
+			convey.So(l1.HasKey("people:P01"), convey.ShouldBeFalse)
+			convey.So(p.HasKey("people:P01"), convey.ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,302 @@
+package msgpack
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// assign sets generic value into dst, converting it to the type of dst
+func assign(dst reflect.Value, src interface{}) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assign(dst.Elem(), src)
+
+	case reflect.Interface:
+		// interface holding pointer (e.g. made by reflector) is decoded into that pointer, as encoding/json does
+		if inner := dst.Elem(); inner.IsValid() && inner.Kind() == reflect.Ptr && !inner.IsNil() {
+			return assign(inner.Elem(), src)
+		}
+		sv := reflect.ValueOf(src)
+		if !sv.Type().AssignableTo(dst.Type()) {
+			return fmt.Errorf("can not decode %T into %s", src, dst.Type())
+		}
+		dst.Set(sv)
+		return nil
+	}
+
+	if dst.Type() == timeType {
+		switch v := src.(type) {
+		case time.Time:
+			dst.Set(reflect.ValueOf(v))
+			return nil
+		case string:
+			t, e := time.Parse(time.RFC3339Nano, v)
+			if e != nil {
+				return e
+			}
+			dst.Set(reflect.ValueOf(t))
+			return nil
+		}
+		return fmt.Errorf("can not decode %T into %s", src, dst.Type())
+	}
+
+	mismatch := fmt.Errorf("can not decode %T into %s", src, dst.Type())
+	switch dst.Kind() {
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatch
+		}
+		dst.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch v := src.(type) {
+		case int64:
+			i = v
+		case float64:
+			i = int64(v)
+			if float64(i) != v {
+				return mismatch
+			}
+		default:
+			return mismatch
+		}
+		if dst.OverflowInt(i) {
+			return fmt.Errorf("%d overflows %s", i, dst.Type())
+		}
+		dst.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch v := src.(type) {
+		case int64:
+			if v < 0 {
+				return mismatch
+			}
+			u = uint64(v)
+		case uint64:
+			u = v
+		case float64:
+			u = uint64(v)
+			if v < 0 || float64(u) != v {
+				return mismatch
+			}
+		default:
+			return mismatch
+		}
+		if dst.OverflowUint(u) {
+			return fmt.Errorf("%d overflows %s", u, dst.Type())
+		}
+		dst.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		switch v := src.(type) {
+		case float64:
+			dst.SetFloat(v)
+		case int64:
+			dst.SetFloat(float64(v))
+		case uint64:
+			dst.SetFloat(float64(v))
+		default:
+			return mismatch
+		}
+
+	case reflect.String:
+		switch v := src.(type) {
+		case string:
+			dst.SetString(v)
+		case []byte:
+			dst.SetString(string(v))
+		default:
+			return mismatch
+		}
+
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			switch v := src.(type) {
+			case []byte:
+				dst.SetBytes(append([]byte{}, v...))
+				return nil
+			case string:
+				dst.SetBytes([]byte(v))
+				return nil
+			}
+		}
+		items, ok := src.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		slice := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			if e := assign(slice.Index(i), item); e != nil {
+				return e
+			}
+		}
+		dst.Set(slice)
+
+	case reflect.Array:
+		if bs, ok := src.([]byte); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			reflect.Copy(dst, reflect.ValueOf(bs))
+			return nil
+		}
+		items, ok := src.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		for i := 0; i < dst.Len() && i < len(items); i++ {
+			if e := assign(dst.Index(i), items[i]); e != nil {
+				return e
+			}
+		}
+
+	case reflect.Map:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		res := reflect.MakeMapWithSize(dst.Type(), len(m))
+		for k, v := range m {
+			key, e := mapKey(dst.Type().Key(), k)
+			if e != nil {
+				return e
+			}
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if e = assign(elem, v); e != nil {
+				return e
+			}
+			res.SetMapIndex(key, elem)
+		}
+		dst.Set(res)
+
+	case reflect.Struct:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		fields := structFields(dst.Type())
+		for k, v := range m {
+			f := findField(fields, k)
+			if f == nil {
+				continue
+			}
+			if e := assign(dst.FieldByIndex(f.index), v); e != nil {
+				return fmt.Errorf("field %s: %s", f.name, e.Error())
+			}
+		}
+
+	default:
+		return fmt.Errorf("unsupported type %s", dst.Type())
+	}
+	return nil
+}
+
+// mapKey converts string key back to key type of map
+func mapKey(t reflect.Type, k string) (reflect.Value, error) {
+	key := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		key.SetString(k)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, e := strconv.ParseInt(k, 10, 64)
+		if e != nil || key.OverflowInt(i) {
+			return key, fmt.Errorf("invalid map key %s for %s", k, t)
+		}
+		key.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, e := strconv.ParseUint(k, 10, 64)
+		if e != nil || key.OverflowUint(u) {
+			return key, fmt.Errorf("invalid map key %s for %s", k, t)
+		}
+		key.SetUint(u)
+	case reflect.Interface:
+		if t.NumMethod() > 0 {
+			return key, fmt.Errorf("unsupported map key type %s", t)
+		}
+		key.Set(reflect.ValueOf(k))
+	default:
+		return key, fmt.Errorf("unsupported map key type %s", t)
+	}
+	return key, nil
+}
+
+type field struct {
+	name  string
+	index []int
+}
+
+var fieldCache sync.Map
+
+// structFields lists exported fields of struct named as encoding/json does, fields of embedded
+// structs without tag are promoted
+func structFields(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+
+	fields := []field{}
+	seen := map[string]bool{}
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		embedded := [][]int{}
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			fieldIndex := append(append([]int{}, index...), i)
+			if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+				embedded = append(embedded, fieldIndex)
+				continue
+			}
+			if sf.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			fields = append(fields, field{name: name, index: fieldIndex})
+		}
+		// promoted fields come after fields of outer struct, which shadow them
+		for _, fieldIndex := range embedded {
+			walk(t.FieldByIndex(fieldIndex[len(index):]).Type, fieldIndex)
+		}
+	}
+	walk(t, nil)
+
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// findField looks for field by exact name then case insensitively, as encoding/json does
+func findField(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
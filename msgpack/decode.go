@@ -0,0 +1,274 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+type decoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+var errShort = errors.New("unexpected end of data")
+
+func (dec *decoder) read(n int) ([]byte, error) {
+	if n < 0 || len(dec.data)-dec.pos < n {
+		return nil, errShort
+	}
+	bs := dec.data[dec.pos : dec.pos+n]
+	dec.pos += n
+	return bs, nil
+}
+
+// checkLen rejects declared length of items taking at least unit bytes each which do not fit in data left,
+// so corrupted or crafted length does not make decoder allocate more than size of data
+func (dec *decoder) checkLen(n, unit int) error {
+	if left := len(dec.data) - dec.pos; n < 0 || n > left/unit {
+		return fmt.Errorf("declared length %d exceeds %d bytes left", n, left)
+	}
+	return nil
+}
+
+// nest enters array or map, it fails when data is nested deeper than MaxDepth
+func (dec *decoder) nest() error {
+	dec.depth++
+	if dec.depth > MaxDepth {
+		return fmt.Errorf("max depth %d exceeded", MaxDepth)
+	}
+	return nil
+}
+
+// readLen reads big endian length of given size in bytes
+func (dec *decoder) readLen(size int) (int, error) {
+	bs, e := dec.read(size)
+	if e != nil {
+		return 0, e
+	}
+	switch size {
+	case 1:
+		return int(bs[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(bs)), nil
+	default:
+		return int(binary.BigEndian.Uint32(bs)), nil
+	}
+}
+
+// decode reads a value in generic form: nil, bool, int64, uint64 (above MaxInt64), float64, string,
+// []byte, time.Time, []interface{} or map[string]interface{}
+func (dec *decoder) decode() (interface{}, error) {
+	bs, e := dec.read(1)
+	if e != nil {
+		return nil, e
+	}
+	code := bs[0]
+
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return dec.decodeMap(int(code & 0x0f))
+	case code&0xf0 == 0x90:
+		return dec.decodeArray(int(code & 0x0f))
+	case code&0xe0 == 0xa0:
+		return dec.decodeString(int(code & 0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+
+	case 0xc4, 0xc5, 0xc6:
+		n, e := dec.readLen(1 << (code - 0xc4))
+		if e != nil {
+			return nil, e
+		}
+		bs, e := dec.read(n)
+		if e != nil {
+			return nil, e
+		}
+		return append([]byte{}, bs...), nil
+
+	case 0xc7, 0xc8, 0xc9:
+		n, e := dec.readLen(1 << (code - 0xc7))
+		if e != nil {
+			return nil, e
+		}
+		return dec.decodeExt(n)
+
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return dec.decodeExt(1 << (code - 0xd4))
+
+	case 0xca:
+		bs, e := dec.read(4)
+		if e != nil {
+			return nil, e
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(bs))), nil
+
+	case 0xcb:
+		bs, e := dec.read(8)
+		if e != nil {
+			return nil, e
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(bs)), nil
+
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		bs, e := dec.read(1 << (code - 0xcc))
+		if e != nil {
+			return nil, e
+		}
+		var u uint64
+		for _, b := range bs {
+			u = u<<8 | uint64(b)
+		}
+		if u > math.MaxInt64 {
+			return u, nil
+		}
+		return int64(u), nil
+
+	case 0xd0:
+		bs, e := dec.read(1)
+		if e != nil {
+			return nil, e
+		}
+		return int64(int8(bs[0])), nil
+	case 0xd1:
+		bs, e := dec.read(2)
+		if e != nil {
+			return nil, e
+		}
+		return int64(int16(binary.BigEndian.Uint16(bs))), nil
+	case 0xd2:
+		bs, e := dec.read(4)
+		if e != nil {
+			return nil, e
+		}
+		return int64(int32(binary.BigEndian.Uint32(bs))), nil
+	case 0xd3:
+		bs, e := dec.read(8)
+		if e != nil {
+			return nil, e
+		}
+		return int64(binary.BigEndian.Uint64(bs)), nil
+
+	case 0xd9, 0xda, 0xdb:
+		n, e := dec.readLen(1 << (code - 0xd9))
+		if e != nil {
+			return nil, e
+		}
+		return dec.decodeString(n)
+
+	case 0xdc, 0xdd:
+		n, e := dec.readLen(2 << (code - 0xdc))
+		if e != nil {
+			return nil, e
+		}
+		return dec.decodeArray(n)
+
+	case 0xde, 0xdf:
+		n, e := dec.readLen(2 << (code - 0xde))
+		if e != nil {
+			return nil, e
+		}
+		return dec.decodeMap(n)
+	}
+	return nil, fmt.Errorf("invalid code 0x%02x", code)
+}
+
+func (dec *decoder) decodeString(n int) (interface{}, error) {
+	bs, e := dec.read(n)
+	if e != nil {
+		return nil, e
+	}
+	return string(bs), nil
+}
+
+func (dec *decoder) decodeArray(n int) (interface{}, error) {
+	// every element takes at least a byte
+	if e := dec.checkLen(n, 1); e != nil {
+		return nil, e
+	}
+	defer func() { dec.depth-- }()
+	if e := dec.nest(); e != nil {
+		return nil, e
+	}
+	res := make([]interface{}, n)
+	for i := range res {
+		v, e := dec.decode()
+		if e != nil {
+			return nil, e
+		}
+		res[i] = v
+	}
+	return res, nil
+}
+
+// decodeMap reads map with its keys converted to string, as keys of map are converted back on assignment
+func (dec *decoder) decodeMap(n int) (interface{}, error) {
+	// every pair takes at least 2 bytes
+	if e := dec.checkLen(n, 2); e != nil {
+		return nil, e
+	}
+	defer func() { dec.depth-- }()
+	if e := dec.nest(); e != nil {
+		return nil, e
+	}
+	res := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, e := dec.decode()
+		if e != nil {
+			return nil, e
+		}
+		v, e := dec.decode()
+		if e != nil {
+			return nil, e
+		}
+		switch key := k.(type) {
+		case string:
+			res[key] = v
+		case []byte:
+			res[string(key)] = v
+		default:
+			res[fmt.Sprint(key)] = v
+		}
+	}
+	return res, nil
+}
+
+func (dec *decoder) decodeExt(n int) (interface{}, error) {
+	typ, e := dec.read(1)
+	if e != nil {
+		return nil, e
+	}
+	bs, e := dec.read(n)
+	if e != nil {
+		return nil, e
+	}
+	if int8(typ[0]) != timeExt {
+		return nil, fmt.Errorf("unsupported extension type %d", int8(typ[0]))
+	}
+
+	// timestamp has no zone, it is decoded as UTC
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(bs)), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(bs)
+		return time.Unix(int64(v&0x3ffffffff), int64(v>>34)).UTC(), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(bs[4:])), int64(binary.BigEndian.Uint32(bs[:4]))).UTC(), nil
+	}
+	return nil, fmt.Errorf("invalid timestamp length %d", n)
+}
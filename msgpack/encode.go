@@ -0,0 +1,215 @@
+package msgpack
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+type encoder struct {
+	buf []byte
+}
+
+// encode writes v, depth is nesting level of v
+func (enc *encoder) encode(v reflect.Value, depth int) error {
+	if depth > MaxDepth {
+		return fmt.Errorf("max depth %d exceeded, value may be cyclic", MaxDepth)
+	}
+	if !v.IsValid() {
+		enc.buf = append(enc.buf, 0xc0)
+		return nil
+	}
+	if v.Type() == timeType {
+		enc.writeTime(v.Interface().(time.Time))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			enc.buf = append(enc.buf, 0xc0)
+			return nil
+		}
+		return enc.encode(v.Elem(), depth+1)
+
+	case reflect.Bool:
+		if v.Bool() {
+			enc.buf = append(enc.buf, 0xc3)
+		} else {
+			enc.buf = append(enc.buf, 0xc2)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		enc.writeInt(v.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		enc.writeUint(v.Uint())
+
+	case reflect.Float32:
+		enc.buf = append(enc.buf, 0xca)
+		enc.buf = appendUint32(enc.buf, math.Float32bits(float32(v.Float())))
+
+	case reflect.Float64:
+		enc.buf = append(enc.buf, 0xcb)
+		enc.buf = appendUint64(enc.buf, math.Float64bits(v.Float()))
+
+	case reflect.String:
+		enc.writeString(v.String())
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			enc.buf = append(enc.buf, 0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			bs := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(bs), v)
+			enc.writeBin(bs)
+			return nil
+		}
+		enc.writeHeader(v.Len(), 0x90, 0xdc, 0xdd, 16)
+		for i := 0; i < v.Len(); i++ {
+			if e := enc.encode(v.Index(i), depth+1); e != nil {
+				return e
+			}
+		}
+
+	case reflect.Map:
+		if v.IsNil() {
+			enc.buf = append(enc.buf, 0xc0)
+			return nil
+		}
+		keys := v.MapKeys()
+		if v.Type().Key().Kind() == reflect.String {
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		}
+		enc.writeHeader(len(keys), 0x80, 0xde, 0xdf, 16)
+		for _, key := range keys {
+			if e := enc.encode(key, depth+1); e != nil {
+				return e
+			}
+			if e := enc.encode(v.MapIndex(key), depth+1); e != nil {
+				return e
+			}
+		}
+
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		enc.writeHeader(len(fields), 0x80, 0xde, 0xdf, 16)
+		for _, f := range fields {
+			enc.writeString(f.name)
+			if e := enc.encode(v.FieldByIndex(f.index), depth+1); e != nil {
+				return e
+			}
+		}
+
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (enc *encoder) writeInt(i int64) {
+	switch {
+	case i >= 0:
+		enc.writeUint(uint64(i))
+	case i >= -32:
+		enc.buf = append(enc.buf, byte(int8(i)))
+	case i >= math.MinInt8:
+		enc.buf = append(enc.buf, 0xd0, byte(int8(i)))
+	case i >= math.MinInt16:
+		enc.buf = append(enc.buf, 0xd1)
+		enc.buf = appendUint16(enc.buf, uint16(int16(i)))
+	case i >= math.MinInt32:
+		enc.buf = append(enc.buf, 0xd2)
+		enc.buf = appendUint32(enc.buf, uint32(int32(i)))
+	default:
+		enc.buf = append(enc.buf, 0xd3)
+		enc.buf = appendUint64(enc.buf, uint64(i))
+	}
+}
+
+func (enc *encoder) writeUint(u uint64) {
+	switch {
+	case u < 128:
+		enc.buf = append(enc.buf, byte(u))
+	case u <= math.MaxUint8:
+		enc.buf = append(enc.buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		enc.buf = append(enc.buf, 0xcd)
+		enc.buf = appendUint16(enc.buf, uint16(u))
+	case u <= math.MaxUint32:
+		enc.buf = append(enc.buf, 0xce)
+		enc.buf = appendUint32(enc.buf, uint32(u))
+	default:
+		enc.buf = append(enc.buf, 0xcf)
+		enc.buf = appendUint64(enc.buf, u)
+	}
+}
+
+func (enc *encoder) writeString(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		enc.buf = append(enc.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		enc.buf = append(enc.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		enc.buf = append(enc.buf, 0xda)
+		enc.buf = appendUint16(enc.buf, uint16(n))
+	default:
+		enc.buf = append(enc.buf, 0xdb)
+		enc.buf = appendUint32(enc.buf, uint32(n))
+	}
+	enc.buf = append(enc.buf, s...)
+}
+
+func (enc *encoder) writeBin(bs []byte) {
+	n := len(bs)
+	switch {
+	case n <= math.MaxUint8:
+		enc.buf = append(enc.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		enc.buf = append(enc.buf, 0xc5)
+		enc.buf = appendUint16(enc.buf, uint16(n))
+	default:
+		enc.buf = append(enc.buf, 0xc6)
+		enc.buf = appendUint32(enc.buf, uint32(n))
+	}
+	enc.buf = append(enc.buf, bs...)
+}
+
+// writeHeader writes length of array or map, fix format holds length below fixMax
+func (enc *encoder) writeHeader(n int, fix, code16, code32 byte, fixMax int) {
+	switch {
+	case n < fixMax:
+		enc.buf = append(enc.buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		enc.buf = append(enc.buf, code16)
+		enc.buf = appendUint16(enc.buf, uint16(n))
+	default:
+		enc.buf = append(enc.buf, code32)
+		enc.buf = appendUint32(enc.buf, uint32(n))
+	}
+}
+
+// writeTime writes timestamp 96 format: ext 8 holding nanoseconds (uint32) and seconds (int64)
+func (enc *encoder) writeTime(t time.Time) {
+	enc.buf = append(enc.buf, 0xc7, 12, 0xff)
+	enc.buf = appendUint32(enc.buf, uint32(t.Nanosecond()))
+	enc.buf = appendUint64(enc.buf, uint64(t.Unix()))
+}
+
+func appendUint16(bs []byte, v uint16) []byte {
+	return append(bs, byte(v>>8), byte(v))
+}
+
+func appendUint32(bs []byte, v uint32) []byte {
+	return append(bs, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(bs []byte, v uint64) []byte {
+	return appendUint32(appendUint32(bs, uint32(v>>32)), uint32(v))
+}
@@ -0,0 +1,39 @@
+package msgpack_test
+
+import (
+	"testing"
+
+	"github.com/sebarcode/kiva/msgpack"
+)
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, v := range []interface{}{nil, true, -1, 1 << 40, 1.5, "text", []byte{1}, []interface{}{1, "a"},
+		map[string]interface{}{"a": 1}, sample()} {
+		bs, e := msgpack.Marshal(v)
+		if e != nil {
+			f.Fatal(e)
+		}
+		f.Add(bs)
+	}
+	f.Add([]byte{0xdd, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xc7, 12, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dest := item{}
+		msgpack.Unmarshal(data, &dest)
+
+		var v interface{}
+		if msgpack.Unmarshal(data, &v) != nil {
+			return
+		}
+		// value decoded in generic form is encoded and decoded again
+		bs, e := msgpack.Marshal(v)
+		if e != nil {
+			t.Fatalf("marshal of decoded %#v: %s", v, e.Error())
+		}
+		var again interface{}
+		if e = msgpack.Unmarshal(bs, &again); e != nil {
+			t.Fatalf("unmarshal of reencoded %#v: %s", v, e.Error())
+		}
+	})
+}
@@ -0,0 +1,52 @@
+// Package msgpack implements MessagePack (https://msgpack.org) for values made of basic types, slices, maps,
+// structs and time.Time, the latter uses timestamp extension. Structs are written as maps keyed by json name
+// of their fields. Values are decoded into generic form first and then assigned to destination, numbers are
+// converted to the destination kind when they fit
+package msgpack
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// MaxDepth bounds nesting of arrays, maps, structs and pointers, it stops encoding of cyclic values
+// and decoding of crafted data from exhausting stack
+const MaxDepth = 512
+
+// timeExt is extension type of timestamp, -1 is written as 0xff
+const timeExt int8 = -1
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Marshal returns MessagePack encoding of value
+func Marshal(value interface{}) ([]byte, error) {
+	enc := &encoder{}
+	if e := enc.encode(reflect.ValueOf(value), 0); e != nil {
+		return nil, fmt.Errorf("msgpack: %s", e.Error())
+	}
+	return enc.buf, nil
+}
+
+// Unmarshal decodes data into dest, which should be a non-nil pointer. Data should hold exactly one value.
+// Value decoded into interface{} uses generic form: nil, bool, int64, uint64 (above MaxInt64), float64,
+// string, []byte, time.Time, []interface{} or map[string]interface{}
+func Unmarshal(data []byte, dest interface{}) error {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("msgpack: destination should be a non-nil pointer")
+	}
+	dec := &decoder{data: data}
+	v, e := dec.decode()
+	if e != nil {
+		return fmt.Errorf("msgpack: %s", e.Error())
+	}
+	if dec.pos != len(data) {
+		return fmt.Errorf("msgpack: %d bytes left after value", len(data)-dec.pos)
+	}
+	if e = assign(rv.Elem(), v); e != nil {
+		return fmt.Errorf("msgpack: %s", e.Error())
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva/msgpack"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type Base struct {
+	ID string `json:"_id"`
+}
+
+type item struct {
+	Base
+	Name    string
+	Age     int
+	Salary  float64
+	Ratio   float32
+	Active  bool
+	Tags    []string
+	Scores  map[string]int
+	Counts  map[int]uint16
+	Raw     []byte
+	Fixed   [2]int
+	Big     uint64
+	Created time.Time
+	Parent  *item
+	Skipped string `json:"-"`
+}
+
+func sample() item {
+	return item{
+		Base:    Base{ID: "M01"},
+		Name:    "Msgpack",
+		Age:     -40,
+		Salary:  1234.5,
+		Ratio:   0.5,
+		Active:  true,
+		Tags:    []string{"a", "b"},
+		Scores:  map[string]int{"x": 1, "y": 70000},
+		Counts:  map[int]uint16{3: 300},
+		Raw:     []byte{0, 1, 2},
+		Fixed:   [2]int{7, -7},
+		Big:     1 << 63,
+		Created: time.Date(2023, 5, 1, 10, 0, 0, 123456789, time.UTC),
+		Parent:  &item{Name: "Parent", Age: 1 << 40},
+	}
+}
+
+func TestMsgpack(t *testing.T) {
+	convey.Convey("value is kept", t, func() {
+		source := sample()
+		bs, e := msgpack.Marshal(source)
+		convey.So(e, convey.ShouldBeNil)
+
+		dest := item{}
+		convey.So(msgpack.Unmarshal(bs, &dest), convey.ShouldBeNil)
+		convey.So(dest, convey.ShouldResemble, source)
+
+		convey.Convey("generic value", func() {
+			var v interface{}
+			convey.So(msgpack.Unmarshal(bs, &v), convey.ShouldBeNil)
+			m := v.(map[string]interface{})
+			convey.So(m["_id"], convey.ShouldEqual, "M01")
+			convey.So(m["Age"], convey.ShouldEqual, int64(-40))
+			convey.So(m["Big"], convey.ShouldEqual, uint64(1<<63))
+			convey.So(m["Tags"], convey.ShouldResemble, []interface{}{"a", "b"})
+			convey.So(m, convey.ShouldNotContainKey, "Skipped")
+		})
+
+		convey.Convey("value not fitting destination", func() {
+			small := struct{ Age uint8 }{}
+			convey.So(msgpack.Unmarshal(bs, &small), convey.ShouldNotBeNil)
+			convey.So(msgpack.Unmarshal(bs, dest), convey.ShouldNotBeNil)
+			convey.So(msgpack.Unmarshal(bs, nil), convey.ShouldNotBeNil)
+		})
+	})
+
+	convey.Convey("malformed data is rejected", t, func() {
+		bs, _ := msgpack.Marshal(sample())
+		var v interface{}
+		for n := 0; n < len(bs); n++ {
+			if msgpack.Unmarshal(bs[:n], &v) == nil {
+				t.Fatalf("truncated data of %d bytes is decoded", n)
+			}
+		}
+		convey.So(msgpack.Unmarshal(append(bs, 0xc0), &v), convey.ShouldNotBeNil)
+
+		for name, data := range map[string][]byte{
+			"invalid code":       {0xc1},
+			"array32 length":     {0xdd, 0xff, 0xff, 0xff, 0xff, 0xc0},
+			"map32 length":       {0xdf, 0xff, 0xff, 0xff, 0xff, 0xc0, 0xc0},
+			"map16 length":       {0xde, 0x00, 0x02, 0xc0, 0xc0, 0xc0},
+			"str32 length":       {0xdb, 0x7f, 0xff, 0xff, 0xff, 'a'},
+			"bin32 length":       {0xc6, 0xff, 0xff, 0xff, 0xff, 0x00},
+			"ext32 length":       {0xc9, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00},
+			"unknown extension":  {0xd4, 0x05, 0x00},
+			"timestamp length":   {0xc7, 3, 0xff, 0, 0, 0},
+			"fixarray truncated": {0x93, 0x01, 0x02},
+		} {
+			if msgpack.Unmarshal(data, &v) == nil {
+				t.Errorf("data with %s is decoded", name)
+			}
+		}
+	})
+
+	convey.Convey("nesting is bounded", t, func() {
+		nested := func(depth int) []byte {
+			bs := bytes.Repeat([]byte{0x91}, depth)
+			return append(bs, 0xc0)
+		}
+		var v interface{}
+		convey.So(msgpack.Unmarshal(nested(msgpack.MaxDepth), &v), convey.ShouldBeNil)
+		convey.So(msgpack.Unmarshal(nested(msgpack.MaxDepth+1), &v), convey.ShouldNotBeNil)
+		convey.So(msgpack.Unmarshal(nested(100000), &v), convey.ShouldNotBeNil)
+
+		type node struct{ Next *node }
+		cyclic := &node{}
+		cyclic.Next = cyclic
+		_, e := msgpack.Marshal(cyclic)
+		convey.So(e, convey.ShouldNotBeNil)
+	})
+}
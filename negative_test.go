@@ -0,0 +1,58 @@
+package kiva_test
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestNegativeCache(t *testing.T) {
+	convey.Convey("key missing on persistent storage", t, func() {
+		var calls int64
+		getter := func(ctx context.Context, key1, key2 string, op kiva.GetKind, dest interface{}) error {
+			atomic.AddInt64(&calls, 1)
+			return io.EOF
+		}
+		kv, e := kiva.NewCtx(context.Background(), kvsimple.New(), nil, getter, nil, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute},
+			NegativeTTL:  200 * time.Millisecond,
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		dest := map[string]interface{}{}
+		convey.So(kv.Get("neg:A", &dest), convey.ShouldEqual, kiva.ErrNotFound)
+		convey.So(kv.Get("neg:A", &dest), convey.ShouldEqual, kiva.ErrNotFound)
+		convey.So(atomic.LoadInt64(&calls), convey.ShouldEqual, 1)
+
+		convey.Convey("marker is hidden from multi key reads", func() {
+			kv.Set("neg:B", map[string]interface{}{"Name": "B"}, nil, false)
+			items := []map[string]interface{}{}
+			convey.So(kv.GetByPattern("neg:*", &items, false), convey.ShouldBeNil)
+			convey.So(len(items), convey.ShouldEqual, 1)
+			convey.So(items[0]["Name"], convey.ShouldEqual, "B")
+
+			items = []map[string]interface{}{}
+			convey.So(kv.GetRange("neg:A", "neg:Z", &items, false), convey.ShouldBeNil)
+			convey.So(len(items), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("set replaces marker", func() {
+			convey.So(kv.Set("neg:A", map[string]interface{}{"Name": "A"}, nil, false), convey.ShouldBeNil)
+			convey.So(kv.Get("neg:A", &dest), convey.ShouldBeNil)
+			convey.So(dest["Name"], convey.ShouldEqual, "A")
+			convey.So(atomic.LoadInt64(&calls), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("marker expires after NegativeTTL", func() {
+			time.Sleep(300 * time.Millisecond)
+			convey.So(kv.Get("neg:A", &dest), convey.ShouldEqual, kiva.ErrNotFound)
+			convey.So(atomic.LoadInt64(&calls), convey.ShouldEqual, 2)
+		})
+	})
+}
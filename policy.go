@@ -0,0 +1,75 @@
+package kiva
+
+import (
+	"fmt"
+	"path"
+	"sync"
+)
+
+// policies keeps write options of tables and key patterns, they are used instead of DefaultWrite
+// whenever kiva writes an item without explicit WriteOptions
+type policies struct {
+	mtx      sync.RWMutex
+	tables   map[string]WriteOptions
+	patterns []patternPolicy
+}
+
+type patternPolicy struct {
+	pattern string
+	opts    WriteOptions
+}
+
+// RegisterTablePolicy sets write options of all keys of given table
+func (k *Kiva) RegisterTablePolicy(tableName string, opts WriteOptions) {
+	k.policies.mtx.Lock()
+	defer k.policies.mtx.Unlock()
+	if k.policies.tables == nil {
+		k.policies.tables = map[string]WriteOptions{}
+	}
+	k.policies.tables[tableName] = opts
+}
+
+// RegisterPatternPolicy sets write options of keys matching pattern (path.Match syntax, e.g. orders:archive_*),
+// it overrides policy of the table. When several patterns match a key, the longest one is used
+func (k *Kiva) RegisterPatternPolicy(pattern string, opts WriteOptions) error {
+	if _, e := path.Match(pattern, ""); e != nil {
+		return fmt.Errorf("invalid policy pattern %s: %s", pattern, e.Error())
+	}
+	k.policies.mtx.Lock()
+	defer k.policies.mtx.Unlock()
+	for i, p := range k.policies.patterns {
+		if p.pattern == pattern {
+			k.policies.patterns[i].opts = opts
+			return nil
+		}
+	}
+	k.policies.patterns = append(k.policies.patterns, patternPolicy{pattern: pattern, opts: opts})
+	return nil
+}
+
+// WriteOptionsOf returns write options applied to key: its pattern policy, its table policy or DefaultWrite
+func (k *Kiva) WriteOptionsOf(key string) WriteOptions {
+	k.policies.mtx.RLock()
+	defer k.policies.mtx.RUnlock()
+
+	matched := -1
+	for i, p := range k.policies.patterns {
+		if ok, _ := path.Match(p.pattern, key); ok && (matched < 0 || len(p.pattern) > len(k.policies.patterns[matched].pattern)) {
+			matched = i
+		}
+	}
+	if matched >= 0 {
+		return k.policies.patterns[matched].opts
+	}
+	if tableName, _, e := ParseKey(key); e == nil {
+		if opts, ok := k.policies.tables[tableName]; ok {
+			return opts
+		}
+	}
+	return k.DefaultWriteOptions()
+}
+
+func (k *Kiva) writeOptions(key string) *WriteOptions {
+	opts := k.WriteOptionsOf(key)
+	return &opts
+}
@@ -0,0 +1,66 @@
+package kiva_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicy(t *testing.T) {
+	convey.Convey("table and pattern policies", t, func() {
+		mtx := new(sync.Mutex)
+		commits := []string{}
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			commits = append(commits, key)
+			return nil
+		}
+		getter := func(ctx context.Context, key, _ string, _ kiva.GetKind, dest interface{}) error {
+			*(dest.(*int)) = 10
+			return nil
+		}
+
+		provider := kvsimple.New()
+		kv, e := kiva.NewCtx(context.Background(), provider, nil, getter, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+		})
+		convey.So(e, convey.ShouldBeNil)
+		kv.RegisterTablePolicy("ref", kiva.WriteOptions{TTL: time.Hour, SyncKind: kiva.SyncNone})
+		kv.RegisterTablePolicy("orders", kiva.WriteOptions{TTL: time.Second, SyncKind: kiva.SyncNow})
+		convey.So(kv.RegisterPatternPolicy("orders:draft_*", kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch}), convey.ShouldBeNil)
+		convey.So(kv.RegisterPatternPolicy("orders:draft_x*", kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNone}), convey.ShouldBeNil)
+		convey.So(kv.RegisterPatternPolicy("orders:[", kiva.WriteOptions{}), convey.ShouldNotBeNil)
+
+		convey.Convey("options are resolved from the most specific policy", func() {
+			convey.So(kv.WriteOptionsOf("ref:A").TTL, convey.ShouldEqual, time.Hour)
+			convey.So(kv.WriteOptionsOf("orders:A").SyncKind, convey.ShouldEqual, kiva.SyncNow)
+			convey.So(kv.WriteOptionsOf("orders:draft_A").SyncKind, convey.ShouldEqual, kiva.SyncBatch)
+			convey.So(kv.WriteOptionsOf("orders:draft_xA").SyncKind, convey.ShouldEqual, kiva.SyncNone)
+			convey.So(kv.WriteOptionsOf("other:A").TTL, convey.ShouldEqual, time.Minute)
+		})
+
+		convey.Convey("set applies policy of the key", func() {
+			convey.So(kv.Set("orders:A", 1, nil, true), convey.ShouldBeNil)
+			convey.So(kv.Set("orders:draft_A", 2, nil, true), convey.ShouldBeNil)
+			convey.So(kv.Set("ref:A", 3, nil, true), convey.ShouldBeNil)
+			convey.So(commits, convey.ShouldResemble, []string{"orders:A"})
+			convey.So(provider.ItemOpts("orders:draft_A").SyncKind, convey.ShouldEqual, kiva.SyncBatch)
+			convey.So(provider.ItemOpts("ref:A").SyncKind, convey.ShouldEqual, kiva.SyncNone)
+		})
+
+		convey.Convey("read-through applies policy of the key", func() {
+			v := 0
+			convey.So(kv.Get("ref:B", &v), convey.ShouldBeNil)
+			convey.So(v, convey.ShouldEqual, 10)
+			opts := provider.ItemOpts("ref:B")
+			convey.So(opts.SyncKind, convey.ShouldEqual, kiva.SyncNone)
+			convey.So(time.Until(opts.Expiry), convey.ShouldBeGreaterThan, 50*time.Minute)
+		})
+	})
+}
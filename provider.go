@@ -17,3 +17,54 @@ type Provider interface {
 	UpdateLastSyncTime(key string) error
 	ItemOpts(key string) *ItemOptions
 }
+
+// ContextProvider is implemented by provider which is able to use context of each request,
+// e.g. as deadline of its network calls. Provider not implementing it uses context given on SetContext
+type ContextProvider interface {
+	SetCtx(ctx context.Context, key string, value interface{}, opts *WriteOptions) error
+	GetCtx(ctx context.Context, key string, dest interface{}) (*ItemOptions, error)
+	DeleteCtx(ctx context.Context, key string)
+	KeysCtx(ctx context.Context, pattern string) []string
+	KeyRangesCtx(ctx context.Context, from, to string) []string
+}
+
+// ProviderSet calls SetCtx when provider implements ContextProvider, otherwise Set
+func ProviderSet(ctx context.Context, p Provider, key string, value interface{}, opts *WriteOptions) error {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.SetCtx(ctx, key, value, opts)
+	}
+	return p.Set(key, value, opts)
+}
+
+// ProviderGet calls GetCtx when provider implements ContextProvider, otherwise Get
+func ProviderGet(ctx context.Context, p Provider, key string, dest interface{}) (*ItemOptions, error) {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.GetCtx(ctx, key, dest)
+	}
+	return p.Get(key, dest)
+}
+
+// ProviderDelete calls DeleteCtx when provider implements ContextProvider, otherwise Delete
+func ProviderDelete(ctx context.Context, p Provider, key string) {
+	if cp, ok := p.(ContextProvider); ok {
+		cp.DeleteCtx(ctx, key)
+		return
+	}
+	p.Delete(key)
+}
+
+// ProviderKeys calls KeysCtx when provider implements ContextProvider, otherwise Keys
+func ProviderKeys(ctx context.Context, p Provider, pattern string) []string {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.KeysCtx(ctx, pattern)
+	}
+	return p.Keys(pattern)
+}
+
+// ProviderKeyRanges calls KeyRangesCtx when provider implements ContextProvider, otherwise KeyRanges
+func ProviderKeyRanges(ctx context.Context, p Provider, from, to string) []string {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.KeyRangesCtx(ctx, from, to)
+	}
+	return p.KeyRanges(from, to)
+}
@@ -0,0 +1,97 @@
+package kiva
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultRefreshConcurrency = 4
+
+// refresher keeps track of background reloads, a key is reloaded by at most one goroutine at a time
+// and number of running reloads is bounded by slots
+type refresher struct {
+	mtx   sync.Mutex
+	keys  map[string]struct{}
+	slots chan struct{}
+}
+
+func newRefresher(maxConcurrent int) *refresher {
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultRefreshConcurrency
+	}
+	return &refresher{
+		keys:  make(map[string]struct{}),
+		slots: make(chan struct{}, maxConcurrent),
+	}
+}
+
+// acquire reserves a slot for key, it returns false when key is being reloaded or all slots are busy
+func (r *refresher) acquire(key string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.keys[key]; ok {
+		return false
+	}
+	select {
+	case r.slots <- struct{}{}:
+	default:
+		return false
+	}
+	r.keys[key] = struct{}{}
+	return true
+}
+
+func (r *refresher) release(key string) {
+	r.mtx.Lock()
+	delete(r.keys, key)
+	r.mtx.Unlock()
+	<-r.slots
+}
+
+// canRefresh tells whether item may be replaced by a fresh copy from persistent storage
+func (k *Kiva) canRefresh(opts *ItemOptions) bool {
+	return k.getter != nil && !opts.Hidden() && opts.SyncDirection != SyncToPersistent
+}
+
+// retained returns write options keeping item on provider long enough to be served stale
+// when StaleWhileRevalidate is enabled
+func (k *Kiva) retained(opts *WriteOptions) *WriteOptions {
+	if !k.opts.Refresh.StaleWhileRevalidate || opts.Kind != "" || opts.TTL <= 0 {
+		return opts
+	}
+	staleFor := k.opts.Refresh.StaleFor
+	if staleFor <= 0 {
+		staleFor = opts.TTL
+	}
+	if opts.GracePeriod >= staleFor {
+		return opts
+	}
+	retainedOpts := *opts
+	retainedOpts.GracePeriod = staleFor
+	return &retainedOpts
+}
+
+// refreshAhead tells whether item has passed AheadRatio of its TTL
+func (k *Kiva) refreshAhead(opts *ItemOptions, now time.Time) bool {
+	ratio := k.opts.Refresh.AheadRatio
+	if ratio <= 0 || opts.ExpiryExtendDuration <= 0 || !k.canRefresh(opts) {
+		return false
+	}
+	written := opts.Expiry.Add(-opts.ExpiryExtendDuration)
+	return now.Sub(written) >= time.Duration(float64(opts.ExpiryExtendDuration)*ratio)
+}
+
+// refreshAsync reloads key thru getter on background, it shares getter call with concurrent Get of the same key
+func (k *Kiva) refreshAsync(key string) {
+	if !k.refresher.acquire(key) {
+		atomic.AddInt64(&k.stats.refreshSkipped, 1)
+		return
+	}
+	atomic.AddInt64(&k.stats.refreshes, 1)
+	go func() {
+		defer k.refresher.release(key)
+		tableName, _, _ := ParseKey(key)
+		k.load(k.ctx, key, k.newItem(tableName))
+	}()
+}
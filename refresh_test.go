@@ -0,0 +1,139 @@
+package kiva_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestRefresh(t *testing.T) {
+	convey.Convey("background refresh", t, func() {
+		var version int64
+		getter := func(ctx context.Context, key1, key2 string, op kiva.GetKind, dest interface{}) error {
+			n := int(atomic.AddInt64(&version, 1))
+			switch d := dest.(type) {
+			case *int:
+				*d = n
+			case *interface{}:
+				*d = n
+			}
+			return nil
+		}
+		reflector := func(string) interface{} { return 0 }
+		newKiva := func(ttl time.Duration, refresh kiva.RefreshOptions) *kiva.Kiva {
+			kv, e := kiva.NewCtx(context.Background(), kvsimple.New(), reflector, getter, nil, &kiva.KivaOptions{
+				DefaultWrite: kiva.WriteOptions{TTL: ttl},
+				Refresh:      refresh,
+			})
+			convey.So(e, convey.ShouldBeNil)
+			return kv
+		}
+		waitVersion := func(kv *kiva.Kiva, key string, want int) int {
+			v := 0
+			for i := 0; i < 50; i++ {
+				kv.Get(key, &v)
+				if v == want {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return v
+		}
+
+		convey.Convey("stale value is served while it is reloaded", func() {
+			kv := newKiva(100*time.Millisecond, kiva.RefreshOptions{StaleWhileRevalidate: true})
+			v := 0
+			convey.So(kv.Get("swr:A", &v), convey.ShouldBeNil)
+			convey.So(v, convey.ShouldEqual, 1)
+
+			time.Sleep(150 * time.Millisecond)
+			convey.So(kv.Get("swr:A", &v), convey.ShouldBeNil)
+			convey.So(v, convey.ShouldEqual, 1)
+			convey.So(waitVersion(kv, "swr:A", 2), convey.ShouldEqual, 2)
+			convey.So(kv.Stats().Refreshes, convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("expired item is not served without stale-while-revalidate", func() {
+			kv := newKiva(100*time.Millisecond, kiva.RefreshOptions{})
+			v := 0
+			convey.So(kv.Get("swr:B", &v), convey.ShouldBeNil)
+			time.Sleep(150 * time.Millisecond)
+			convey.So(kv.Get("swr:B", &v), convey.ShouldNotBeNil)
+		})
+
+		convey.Convey("hot item is reloaded before it expires", func() {
+			kv := newKiva(time.Second, kiva.RefreshOptions{AheadRatio: 0.2})
+			v := 0
+			convey.So(kv.Get("ahead:A", &v), convey.ShouldBeNil)
+			convey.So(v, convey.ShouldEqual, 1)
+
+			kv.Get("ahead:A", &v)
+			convey.So(kv.Stats().Refreshes, convey.ShouldEqual, 0)
+
+			time.Sleep(300 * time.Millisecond)
+			convey.So(kv.Get("ahead:A", &v), convey.ShouldBeNil)
+			convey.So(v, convey.ShouldEqual, 1)
+			convey.So(waitVersion(kv, "ahead:A", 2), convey.ShouldEqual, 2)
+		})
+
+		convey.Convey("unsynced item is not reloaded", func() {
+			kv := newKiva(time.Second, kiva.RefreshOptions{AheadRatio: 0.2})
+			kv.Set("ahead:B", 100, nil, false)
+			time.Sleep(300 * time.Millisecond)
+			v := 0
+			convey.So(kv.Get("ahead:B", &v), convey.ShouldBeNil)
+			convey.So(v, convey.ShouldEqual, 100)
+			convey.So(kv.Stats().Refreshes, convey.ShouldEqual, 0)
+		})
+	})
+
+	convey.Convey("write made while item is reloaded is kept", t, func() {
+		var calls int64
+		reloading, release := make(chan struct{}), make(chan struct{})
+		getter := func(ctx context.Context, key1, key2 string, op kiva.GetKind, dest interface{}) error {
+			if atomic.AddInt64(&calls, 1) > 1 {
+				close(reloading)
+				<-release
+			}
+			switch d := dest.(type) {
+			case *int:
+				*d = 1
+			case *interface{}:
+				*d = 1
+			}
+			return nil
+		}
+		var committed int64
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			atomic.StoreInt64(&committed, int64(value.(int)))
+			return nil
+		}
+		reflector := func(string) interface{} { return 0 }
+		kv, e := kiva.NewCtx(context.Background(), kvsimple.New(), reflector, getter, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Second, SyncKind: kiva.SyncBatch},
+			Refresh:      kiva.RefreshOptions{AheadRatio: 0.01},
+		})
+		convey.So(e, convey.ShouldBeNil)
+		v := 0
+		convey.So(kv.Get("t:a", &v), convey.ShouldBeNil)
+
+		time.Sleep(20 * time.Millisecond)
+		convey.So(kv.Get("t:a", &v), convey.ShouldBeNil)
+		<-reloading
+		convey.So(kv.Set("t:a", 2, nil, true), convey.ShouldBeNil)
+		close(release)
+		time.Sleep(50 * time.Millisecond)
+
+		report, e := kv.SyncNow(context.Background())
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(report.Committed, convey.ShouldResemble, []string{"t:a"})
+		convey.So(atomic.LoadInt64(&committed), convey.ShouldEqual, 2)
+		convey.So(kv.Get("t:a", &v), convey.ShouldBeNil)
+		convey.So(v, convey.ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,166 @@
+package kiva
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+type scheduleEntry struct {
+	key   string
+	due   time.Time
+	index int
+}
+
+type scheduleHeap []*scheduleEntry
+
+func (h scheduleHeap) Len() int           { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h scheduleHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *scheduleHeap) Push(x interface{}) {
+	e := x.(*scheduleEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// scheduler keeps keys which need sync ordered by the time they are due, a key has at most one entry.
+// Dirty keys are due immediately, synced keys are due when their refresh interval passes
+type scheduler struct {
+	mtx     sync.Mutex
+	heap    scheduleHeap
+	entries map[string]*scheduleEntry
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{entries: make(map[string]*scheduleEntry)}
+}
+
+// schedule sets due time of key, it replaces previous due time of the key
+func (s *scheduler) schedule(key string, due time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if e, ok := s.entries[key]; ok {
+		e.due = due
+		heap.Fix(&s.heap, e.index)
+		return
+	}
+	e := &scheduleEntry{key: key, due: due}
+	s.entries[key] = e
+	heap.Push(&s.heap, e)
+}
+
+func (s *scheduler) remove(key string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if e, ok := s.entries[key]; ok {
+		heap.Remove(&s.heap, e.index)
+		delete(s.entries, key)
+	}
+}
+
+// due counts keys due at given time and returns due time of the earliest key
+func (s *scheduler) due(now time.Time) (int, time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.heap) == 0 {
+		return 0, time.Time{}
+	}
+	count := 0
+	for _, e := range s.heap {
+		if !e.due.After(now) {
+			count++
+		}
+	}
+	return count, s.heap[0].due
+}
+
+// popDue removes and returns keys due at given time, the earliest first
+func (s *scheduler) popDue(now time.Time) []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	keys := []string{}
+	for len(s.heap) > 0 && !s.heap[0].due.After(now) {
+		e := heap.Pop(&s.heap).(*scheduleEntry)
+		delete(s.entries, e.key)
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+func (s *scheduler) len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.heap)
+}
+
+// schedulingEnabled tells whether background sync runs, keys are not tracked otherwise
+func (k *Kiva) schedulingEnabled() bool {
+	return k.SyncOptions().EveryInSecond > 0
+}
+
+// markDirty schedules key to be visited on next sync pass
+func (k *Kiva) markDirty(key string) {
+	if k.schedulingEnabled() {
+		k.scheduler.schedule(key, time.Now())
+	}
+}
+
+// scheduleRefresh schedules refresh of item just read from persistent storage with given write options
+func (k *Kiva) scheduleRefresh(key string, opts *WriteOptions) {
+	if !k.schedulingEnabled() || opts.SyncKind == SyncNone || (k.getter == nil && k.opts.BatchGetter == nil) {
+		return
+	}
+	k.scheduler.schedule(key, time.Now().Add(time.Duration(opts.SyncEveryInSecond)*time.Second))
+}
+
+// reschedule computes next sync of key from its current options on provider
+func (k *Kiva) reschedule(key string) {
+	if !k.schedulingEnabled() {
+		return
+	}
+	k.scheduleOpts(key, k.provider.ItemOpts(key))
+}
+
+// scheduleOpts is reschedule for caller which has read options of the item
+func (k *Kiva) scheduleOpts(key string, opts *ItemOptions) {
+	if due, ok := k.nextSync(opts); ok {
+		k.scheduler.schedule(key, due)
+		return
+	}
+	k.scheduler.remove(key)
+}
+
+// nextSync is the time item should be visited by sync, false when item needs no sync
+func (k *Kiva) nextSync(opts *ItemOptions) (time.Time, bool) {
+	if opts == nil || opts.Kind == ItemNegative || opts.SyncKind == SyncNone {
+		return time.Time{}, false
+	}
+	switch opts.SyncDirection {
+	case SyncToPersistent:
+		if !k.hasCommitter() {
+			return time.Time{}, false
+		}
+		if !opts.NextCommit.IsZero() {
+			return opts.NextCommit, true
+		}
+		return time.Now(), true
+
+	case SyncToHots:
+		if opts.Kind == ItemTombstone || (k.getter == nil && k.opts.BatchGetter == nil) {
+			return time.Time{}, false
+		}
+		return opts.LastSync.Add(time.Duration(opts.SyncEveryInSecond) * time.Second), true
+	}
+	return time.Time{}, false
+}
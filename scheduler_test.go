@@ -0,0 +1,78 @@
+package kiva_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// countingProvider counts reads of provider
+type countingProvider struct {
+	kiva.Provider
+	gets     int64
+	itemOpts int64
+}
+
+func (p *countingProvider) Get(key string, dest interface{}) (*kiva.ItemOptions, error) {
+	atomic.AddInt64(&p.gets, 1)
+	return p.Provider.Get(key, dest)
+}
+
+func (p *countingProvider) ItemOpts(key string) *kiva.ItemOptions {
+	atomic.AddInt64(&p.itemOpts, 1)
+	return p.Provider.ItemOpts(key)
+}
+
+func TestScheduledSync(t *testing.T) {
+	convey.Convey("sync visits only dirty and due keys", t, func() {
+		mtx := new(sync.Mutex)
+		committed := map[string]int{}
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			committed[key]++
+			return nil
+		}
+		commits := func(key string) int {
+			mtx.Lock()
+			defer mtx.Unlock()
+			return committed[key]
+		}
+		getter := func(ctx context.Context, key1, key2 string, op kiva.GetKind, dest interface{}) error {
+			return nil
+		}
+
+		provider := &countingProvider{Provider: kvsimple.New()}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		kv, e := kiva.NewCtx(ctx, provider, func(string) interface{} { return 0 }, getter, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Hour, SyncKind: kiva.SyncBatch, SyncEveryInSecond: 3600},
+			SyncBatch:    kiva.SyncBatchOptions{EveryInSecond: 1},
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		kv.Set("sch:0000", 0, &kiva.WriteOptions{TTL: time.Hour, SyncKind: kiva.SyncNone}, false)
+		for i := 1; i <= 500; i++ {
+			kv.Set(fmt.Sprintf("sch:%04d", i), i, nil, false)
+		}
+		time.Sleep(1200 * time.Millisecond)
+		convey.So(commits("sch:0000"), convey.ShouldEqual, 0)
+		convey.So(commits("sch:0001"), convey.ShouldEqual, 1)
+		convey.So(commits("sch:0500"), convey.ShouldEqual, 1)
+
+		convey.Convey("clean keys are not visited", func() {
+			before := atomic.LoadInt64(&provider.gets)
+			kv.Set("sch:0007", 70, nil, false)
+			time.Sleep(1300 * time.Millisecond)
+			convey.So(commits("sch:0007"), convey.ShouldEqual, 2)
+			convey.So(atomic.LoadInt64(&provider.gets)-before, convey.ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,60 @@
+package kiva_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestServeStale(t *testing.T) {
+	convey.Convey("getter fails after item expires", t, func() {
+		var (
+			version int64
+			down    int32
+		)
+		getter := func(ctx context.Context, key1, key2 string, op kiva.GetKind, dest interface{}) error {
+			if atomic.LoadInt32(&down) == 1 {
+				return errors.New("db is down")
+			}
+			*(dest.(*int)) = int(atomic.AddInt64(&version, 1))
+			return nil
+		}
+		kv, e := kiva.NewCtx(context.Background(), kvsimple.New(), nil, getter, nil, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: 100 * time.Millisecond, GracePeriod: 300 * time.Millisecond},
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		v := 0
+		convey.So(kv.Get("stale:A", &v), convey.ShouldBeNil)
+		convey.So(v, convey.ShouldEqual, 1)
+
+		atomic.StoreInt32(&down, 1)
+		time.Sleep(150 * time.Millisecond)
+
+		convey.Convey("expired item is served within grace period", func() {
+			v = 0
+			e := kv.Get("stale:A", &v)
+			convey.So(errors.Is(e, kiva.ErrStale), convey.ShouldBeTrue)
+			convey.So(v, convey.ShouldEqual, 1)
+
+			convey.Convey("item is reloaded once getter recovers", func() {
+				atomic.StoreInt32(&down, 0)
+				convey.So(kv.Get("stale:A", &v), convey.ShouldBeNil)
+				convey.So(v, convey.ShouldEqual, 2)
+			})
+		})
+
+		convey.Convey("expired item is dropped after grace period", func() {
+			time.Sleep(300 * time.Millisecond)
+			e := kv.Get("stale:A", &v)
+			convey.So(e, convey.ShouldNotBeNil)
+			convey.So(errors.Is(e, kiva.ErrStale), convey.ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,49 @@
+package kiva
+
+import "sync/atomic"
+
+// Stats is counters of Kiva activity since it is created
+type Stats struct {
+	// GetterCalls is number of GetterFunc invocations on read-through of single key
+	GetterCalls int64
+	// GetterShared is number of reads which missed the provider and received result of
+	// other concurrent getter call of the same key instead of calling the getter
+	GetterShared int64
+	// Refreshes is number of background reloads started by stale-while-revalidate or refresh-ahead
+	Refreshes int64
+	// RefreshSkipped is number of background reloads not started since the key was being reloaded
+	// or all reload slots were busy
+	RefreshSkipped int64
+	// CommitFailures is number of failed committer calls of write-behind
+	CommitFailures int64
+	// DeadLetters is number of items moved to dead letter store
+	DeadLetters int64
+	// BatchGetterCalls is number of BatchGetterFunc invocations
+	BatchGetterCalls int64
+	// WritesCoalesced is number of SyncNow writes merged into a later write of the same key, i.e. commits saved
+	WritesCoalesced int64
+}
+
+type stats struct {
+	getterCalls      int64
+	getterShared     int64
+	refreshes        int64
+	refreshSkipped   int64
+	commitFailures   int64
+	deadLetters      int64
+	batchGetterCalls int64
+	writesCoalesced  int64
+}
+
+func (k *Kiva) Stats() Stats {
+	return Stats{
+		GetterCalls:      atomic.LoadInt64(&k.stats.getterCalls),
+		GetterShared:     atomic.LoadInt64(&k.stats.getterShared),
+		Refreshes:        atomic.LoadInt64(&k.stats.refreshes),
+		RefreshSkipped:   atomic.LoadInt64(&k.stats.refreshSkipped),
+		CommitFailures:   atomic.LoadInt64(&k.stats.commitFailures),
+		DeadLetters:      atomic.LoadInt64(&k.stats.deadLetters),
+		BatchGetterCalls: atomic.LoadInt64(&k.stats.batchGetterCalls),
+		WritesCoalesced:  atomic.LoadInt64(&k.stats.writesCoalesced),
+	}
+}
@@ -2,7 +2,11 @@ package kiva
 
 import (
 	"context"
+	"errors"
 	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,67 +14,175 @@ func (k *Kiva) SetContext(ctx context.Context) {
 	k.ctx = ctx
 }
 
+// Sync runs sync pass every SyncBatch.EveryInSecond until context of Kiva is done or Kiva is closed. A pass only
+// visits keys which are due on the scheduler, the whole keyspace is scanned on first pass to pick up items written
+// before start (e.g. pending commits on a durable provider), then every SyncBatch.RescanEveryInSecond and
+// after sync options are changed. No pass runs while sync is paused
 func (kv *Kiva) Sync() {
+	var lastScan time.Time
 	for {
+		batchOpts := kv.SyncOptions()
+		var tick <-chan time.Time
+		if batchOpts.EveryInSecond > 0 {
+			tick = time.After(time.Duration(batchOpts.EveryInSecond) * time.Second)
+		}
+
 		select {
 		case <-kv.ctx.Done():
 			return
 
-		case <-time.After(time.Duration(kv.opts.SyncBatch.EveryInSecond) * time.Second):
-			keys := kv.provider.Keys("*")
-			for _, key := range keys {
-				tableName, _, _ := ParseKey(key)
-				item := kv.reflector(tableName)
-				opt, err := kv.provider.Get(key, &item)
-				if err == nil {
-					if opt.SyncKind == SyncNone {
-						break
-					}
-
-					// data exist on hs
-					switch opt.SyncDirection {
-					case SyncToHots:
-						// get difference from last sync
-						if opt.SyncEveryInSecond != 0 {
-							syncDiff := time.Since(opt.LastSync)
-							if syncDiff < time.Duration(opt.SyncEveryInSecond)*time.Second {
-								break
-							}
-						}
-
-						if kv.getter == nil {
-							break
-						}
-						newItem := kv.reflector(tableName)
-						getterErr := kv.getter(key, "", GetByID, &newItem)
-						if getterErr == io.EOF {
-							kv.provider.Delete(key)
-							break
-						} else if getterErr != nil {
-							break
-						}
-						item = newItem
-						kv.Set(key, item, &kv.opts.DefaultWrite, false)
-						kv.provider.UpdateLastSyncTime(key)
-
-					case SyncToPersistent:
-						if kv.commiter == nil {
-							break
-						}
-						err := kv.commiter(key, item, CommitSave)
-						if err != nil {
-							break
-						}
-						opt.SyncDirection = SyncToHots
-						kv.provider.ChangeSyncOpts(key, opt)
-						kv.provider.UpdateLastSyncTime(key)
-					}
-
-				} else {
-					// data not exist on hs, then delete it from hs
-					kv.provider.Delete(key)
-				}
+		case <-kv.stop:
+			return
+
+		case <-kv.wake:
+			// options are changed, next pass uses new interval and rescans keys written meanwhile
+			lastScan = time.Time{}
+
+		case <-tick:
+			if kv.SyncPaused() {
+				continue
+			}
+			rescanEvery := time.Duration(batchOpts.RescanEveryInSecond) * time.Second
+			if lastScan.IsZero() || (rescanEvery > 0 && time.Since(lastScan) >= rescanEvery) {
+				kv.rescan(kv.ctx)
+				lastScan = time.Now()
 			}
+			kv.syncKeys(kv.ctx, kv.scheduler.popDue(time.Now()), false)
+			atomic.StoreInt64(&kv.lastPass, time.Now().UnixNano())
+		}
+	}
+}
+
+// rescan schedules all keys of provider according to their options, marker items written by other
+// processes sharing the provider are picked up here as well
+func (kv *Kiva) rescan(ctx context.Context) {
+	for _, key := range ProviderKeys(ctx, kv.provider, "*") {
+		opts := kv.provider.ItemOpts(key)
+		kv.markers.seen(key, opts)
+		kv.scheduleOpts(key, opts)
+	}
+}
+
+// syncKeys commits dirty items and refreshes hot items of given keys, then schedules their next visit
+// Keys are processed by sync workers, items collected for BatchCommitter and BatchGetter are handed over
+// after all keys are visited. On flush only dirty items are committed, regardless of their retry backoff
+func (kv *Kiva) syncKeys(ctx context.Context, keys []string, flush bool) SyncReport {
+	col := &syncCollector{batch: commitBatch{}, report: new(syncReport)}
+	tasks := make([]syncTask, len(keys))
+	for i, key := range keys {
+		key := key
+		tableName, _, _ := ParseKey(key)
+		tasks[i] = syncTask{tableName: tableName, run: func(ctx context.Context) {
+			kv.syncKey(ctx, key, col, flush)
+		}}
+	}
+	kv.runTasks(ctx, tasks)
+	kv.runTasks(ctx, kv.commitTasks(col.batch, col.report))
+	kv.runTasks(ctx, kv.refreshTasks(col.refreshKeys, col.report))
+
+	for _, key := range keys {
+		kv.reschedule(key)
+	}
+	return col.report.result()
+}
+
+// syncCollector gathers items of a sync pass to be handled in batch, it is shared by sync workers
+type syncCollector struct {
+	mtx         sync.Mutex
+	batch       commitBatch
+	refreshKeys []string
+	report      *syncReport
+}
+
+func (c *syncCollector) addCommit(key string, value interface{}, op CommitKind, opts *ItemOptions) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.batch.add(key, value, op, opts)
+}
+
+func (c *syncCollector) addRefresh(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.refreshKeys = append(c.refreshKeys, key)
+}
+
+func (kv *Kiva) syncKey(ctx context.Context, key string, col *syncCollector, flush bool) {
+	unlock := kv.keyLocks.lock(key)
+	defer unlock()
+
+	tableName, _, _ := ParseKey(key)
+	item := kv.newItem(tableName)
+	opt, err := ProviderGet(ctx, kv.provider, key, item)
+	if errors.Is(err, io.EOF) {
+		// data not exist on hs, then delete it from hs
+		ProviderDelete(ctx, kv.provider, key)
+		col.report.add(key, syncEvicted)
+		return
+	}
+	if err != nil {
+		// e.g. provider is not reachable or value can not be decoded, item is kept for next pass
+		col.report.add(key, syncFailed)
+		return
+	}
+	if opt.Kind == ItemNegative || opt.SyncKind == SyncNone {
+		return
+	}
+	if flush && opt.SyncDirection != SyncToPersistent {
+		return
+	}
+
+	// data exist on hs
+	switch opt.SyncDirection {
+	case SyncToHots:
+		// get difference from last sync
+		if opt.SyncEveryInSecond != 0 {
+			syncDiff := time.Since(opt.LastSync)
+			if syncDiff < time.Duration(opt.SyncEveryInSecond)*time.Second {
+				return
+			}
+		}
+
+		if kv.opts.BatchGetter != nil {
+			col.addRefresh(key)
+			return
+		}
+		if kv.getter == nil {
+			return
+		}
+		newItem := kv.newItem(tableName)
+		getterErr := kv.getter(ctx, key, "", GetByID, newItem)
+		if getterErr == io.EOF {
+			ProviderDelete(ctx, kv.provider, key)
+			col.report.add(key, syncEvicted)
+			return
+		} else if getterErr != nil {
+			col.report.add(key, syncFailed)
+			return
+		}
+		kv.set(ctx, key, reflect.Indirect(reflect.ValueOf(newItem)).Interface(), kv.writeOptions(key), false)
+		kv.provider.UpdateLastSyncTime(key)
+		col.report.add(key, syncRefreshed)
+
+	case SyncToPersistent:
+		if !kv.hasCommitter() {
+			return
+		}
+		// failed commit waits for its backoff, coalesced write waits for its timer
+		if !flush && (opt.NextCommit.After(time.Now()) || kv.coalescer.pending(key)) {
+			return
+		}
+		value, op := reflect.Indirect(reflect.ValueOf(item)).Interface(), CommitSave
+		if opt.Kind == ItemTombstone {
+			value, op = nil, CommitDelete
+		}
+		if kv.opts.BatchCommitter != nil {
+			col.addCommit(key, value, op, opt)
+			return
+		}
+		if kv.commit(ctx, key, value, op, opt) != nil {
+			col.report.add(key, syncFailed)
+			return
 		}
+		col.report.add(key, syncCommitted)
 	}
 }
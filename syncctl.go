@@ -0,0 +1,108 @@
+package kiva
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type SyncStateEnum string
+
+const (
+	SyncStopped    SyncStateEnum = "STOPPED"
+	SyncRunning    SyncStateEnum = "RUNNING"
+	SyncPaused     SyncStateEnum = "PAUSED"
+	SyncBacklogged SyncStateEnum = "BACKLOGGED"
+)
+
+// SyncStatus describes background sync. A due key normally waits at most for one interval and one pass,
+// sync is Backlogged when some keys have been due for more than 2 intervals
+type SyncStatus struct {
+	State     SyncStateEnum
+	Scheduled int       // keys tracked by scheduler
+	Due       int       // keys due for next pass
+	OldestDue time.Time // due time of the earliest key
+	LastPass  time.Time
+}
+
+// PauseSync stops periodic sync passes and coalesced commits, dirty items stay on provider until ResumeSync.
+// Writes with SyncNow which are not coalesced, SyncNow/SyncTable/SyncKeys and Close still commit
+func (k *Kiva) PauseSync() {
+	atomic.StoreInt32(&k.paused, 1)
+}
+
+// ResumeSync resumes periodic sync, items written while paused are picked up by next pass
+func (k *Kiva) ResumeSync() {
+	if atomic.CompareAndSwapInt32(&k.paused, 1, 0) {
+		k.wakeSync()
+	}
+}
+
+func (k *Kiva) SyncPaused() bool {
+	return atomic.LoadInt32(&k.paused) == 1
+}
+
+// SyncOptions returns current SyncBatch options
+func (k *Kiva) SyncOptions() SyncBatchOptions {
+	k.optsMtx.RLock()
+	defer k.optsMtx.RUnlock()
+	return k.opts.SyncBatch
+}
+
+// SetSyncOptions replaces SyncBatch options at runtime, sync loop applies new interval right away.
+// Setting EveryInSecond to 0 stops periodic sync, setting it back starts it again
+func (k *Kiva) SetSyncOptions(opts SyncBatchOptions) {
+	k.optsMtx.Lock()
+	k.opts.SyncBatch = opts
+	k.optsMtx.Unlock()
+	k.wakeSync()
+}
+
+// DefaultWriteOptions returns current DefaultWrite options
+func (k *Kiva) DefaultWriteOptions() WriteOptions {
+	k.optsMtx.RLock()
+	defer k.optsMtx.RUnlock()
+	return k.opts.DefaultWrite
+}
+
+// SetDefaultWrite replaces DefaultWrite options at runtime, it applies to subsequent writes and loads of keys
+// without policy only
+func (k *Kiva) SetDefaultWrite(opts WriteOptions) {
+	k.optsMtx.Lock()
+	defer k.optsMtx.Unlock()
+	k.opts.DefaultWrite = opts
+}
+
+// wakeSync makes sync loop re-read its options, it never blocks
+func (k *Kiva) wakeSync() {
+	select {
+	case k.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SyncStatus returns state of background sync
+func (k *Kiva) SyncStatus() SyncStatus {
+	now := time.Now()
+	status := SyncStatus{State: SyncRunning, Scheduled: k.scheduler.len()}
+	status.Due, status.OldestDue = k.scheduler.due(now)
+	if lastPass := atomic.LoadInt64(&k.lastPass); lastPass > 0 {
+		status.LastPass = time.Unix(0, lastPass)
+	}
+
+	every := time.Duration(k.SyncOptions().EveryInSecond) * time.Second
+	select {
+	case <-k.loopDone:
+		status.State = SyncStopped
+		return status
+	default:
+	}
+	switch {
+	case every <= 0:
+		status.State = SyncStopped
+	case k.SyncPaused():
+		status.State = SyncPaused
+	case status.Due > 0 && now.Sub(status.OldestDue) > 2*every:
+		status.State = SyncBacklogged
+	}
+	return status
+}
@@ -0,0 +1,61 @@
+package kiva_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestSyncControl(t *testing.T) {
+	convey.Convey("pause, resume and reconfigure sync", t, func() {
+		mtx := new(sync.Mutex)
+		commits := map[string]interface{}{}
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			commits[key] = value
+			return nil
+		}
+		committed := func(key string) bool {
+			mtx.Lock()
+			defer mtx.Unlock()
+			_, ok := commits[key]
+			return ok
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		kv, e := kiva.NewCtx(ctx, kvsimple.New(), nil, nil, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+		})
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(kv.Set("ctl:A", 1, nil, true), convey.ShouldBeNil)
+		convey.So(kv.SyncStatus().State, convey.ShouldEqual, kiva.SyncStopped)
+
+		kv.SetSyncOptions(kiva.SyncBatchOptions{EveryInSecond: 1})
+		kv.PauseSync()
+		convey.So(kv.Set("ctl:B", 2, nil, true), convey.ShouldBeNil)
+		time.Sleep(1300 * time.Millisecond)
+		convey.So(committed("ctl:A"), convey.ShouldBeFalse)
+		status := kv.SyncStatus()
+		convey.So(status.State, convey.ShouldEqual, kiva.SyncPaused)
+		convey.So(status.Due, convey.ShouldEqual, 1)
+
+		kv.ResumeSync()
+		time.Sleep(1300 * time.Millisecond)
+		convey.So(committed("ctl:A"), convey.ShouldBeTrue)
+		convey.So(committed("ctl:B"), convey.ShouldBeTrue)
+		status = kv.SyncStatus()
+		convey.So(status.State, convey.ShouldEqual, kiva.SyncRunning)
+		convey.So(status.LastPass.IsZero(), convey.ShouldBeFalse)
+
+		kv.SetDefaultWrite(kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNone})
+		convey.So(kv.Set("ctl:C", 3, nil, true), convey.ShouldBeNil)
+		convey.So(kv.SyncStatus().Due, convey.ShouldEqual, 0)
+	})
+}
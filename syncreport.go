@@ -0,0 +1,73 @@
+package kiva
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// SyncReport lists keys handled by an on-demand sync pass
+type SyncReport struct {
+	Committed []string // dirty items written (or deleted) on persistent storage
+	Refreshed []string // hot items reloaded from persistent storage
+	Evicted   []string // items removed as they no longer exist
+	Failed    []string // items failed to be committed or refreshed, committed ones will be retried by Sync
+}
+
+type syncOutcome int
+
+const (
+	syncCommitted syncOutcome = iota
+	syncRefreshed
+	syncEvicted
+	syncFailed
+)
+
+// syncReport collects outcome of a sync pass, it is shared by sync workers
+type syncReport struct {
+	mtx    sync.Mutex
+	report SyncReport
+}
+
+func (r *syncReport) add(key string, outcome syncOutcome) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	switch outcome {
+	case syncCommitted:
+		r.report.Committed = append(r.report.Committed, key)
+	case syncRefreshed:
+		r.report.Refreshed = append(r.report.Refreshed, key)
+	case syncEvicted:
+		r.report.Evicted = append(r.report.Evicted, key)
+	case syncFailed:
+		r.report.Failed = append(r.report.Failed, key)
+	}
+}
+
+func (r *syncReport) result() SyncReport {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	res := r.report
+	for _, keys := range [][]string{res.Committed, res.Refreshed, res.Evicted, res.Failed} {
+		sort.Strings(keys)
+	}
+	return res
+}
+
+// SyncNow runs a sync pass over all keys of provider and waits for it. Unlike periodic Sync it does not wait
+// for keys to be due, but items still waiting for their retry backoff or SyncEveryInSecond are skipped
+func (k *Kiva) SyncNow(ctx context.Context) (SyncReport, error) {
+	return k.SyncKeys(ctx, ProviderKeys(ctx, k.provider, "*")...)
+}
+
+// SyncTable runs a sync pass over keys of given table and waits for it
+func (k *Kiva) SyncTable(ctx context.Context, tableName string) (SyncReport, error) {
+	return k.SyncKeys(ctx, ProviderKeys(ctx, k.provider, tableName+":*")...)
+}
+
+// SyncKeys runs a sync pass over given keys and waits for it, error is returned when ctx is done before
+// all keys are handled
+func (k *Kiva) SyncKeys(ctx context.Context, keys ...string) (SyncReport, error) {
+	report := k.syncKeys(ctx, keys, false)
+	return report, ctx.Err()
+}
@@ -0,0 +1,132 @@
+package kiva_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestSyncNow(t *testing.T) {
+	convey.Convey("on-demand sync", t, func() {
+		mtx := new(sync.Mutex)
+		persistent := map[string]interface{}{}
+		getter := func(ctx context.Context, key, _ string, _ kiva.GetKind, dest interface{}) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			v, ok := persistent[key]
+			if !ok {
+				return io.EOF
+			}
+			switch d := dest.(type) {
+			case *int:
+				*d = v.(int)
+			case *interface{}:
+				*d = v
+			}
+			return nil
+		}
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if key == "sn:FAIL" {
+				return errors.New("db is down")
+			}
+			persistent[key] = value
+			return nil
+		}
+
+		kv, e := kiva.NewCtx(context.Background(), kvsimple.New(), nil, getter, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+		})
+		convey.So(e, convey.ShouldBeNil)
+		for _, key := range []string{"sn:A", "sn:B", "sn:FAIL", "other:A"} {
+			convey.So(kv.Set(key, 1, nil, true), convey.ShouldBeNil)
+		}
+
+		convey.Convey("dirty items are committed without waiting for sync loop", func() {
+			report, e := kv.SyncTable(context.Background(), "sn")
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(report.Committed, convey.ShouldResemble, []string{"sn:A", "sn:B"})
+			convey.So(report.Failed, convey.ShouldResemble, []string{"sn:FAIL"})
+			convey.So(persistent, convey.ShouldNotContainKey, "other:A")
+
+			convey.Convey("synced items are refreshed or evicted on next pass", func() {
+				mtx.Lock()
+				persistent["sn:A"] = 2
+				delete(persistent, "sn:B")
+				mtx.Unlock()
+
+				report, e := kv.SyncKeys(context.Background(), "sn:A", "sn:B")
+				convey.So(e, convey.ShouldBeNil)
+				convey.So(report.Refreshed, convey.ShouldResemble, []string{"sn:A"})
+				convey.So(report.Evicted, convey.ShouldResemble, []string{"sn:B"})
+
+				v := 0
+				convey.So(kv.Get("sn:A", &v), convey.ShouldBeNil)
+				convey.So(v, convey.ShouldEqual, 2)
+			})
+		})
+
+		convey.Convey("sync now visits all tables", func() {
+			report, e := kv.SyncNow(context.Background())
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(report.Committed, convey.ShouldResemble, []string{"other:A", "sn:A", "sn:B"})
+		})
+	})
+	convey.Convey("item failing to be read is kept", t, func() {
+		provider := &unreadableProvider{Provider: kvsimple.New(), key: "sr:BAD"}
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			return nil
+		}
+		kv, e := kiva.NewCtx(context.Background(), provider, nil, nil, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+		})
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(kv.Set("sr:BAD", 1, nil, true), convey.ShouldBeNil)
+
+		report, e := kv.SyncKeys(context.Background(), "sr:BAD", "sr:GONE")
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(report.Failed, convey.ShouldResemble, []string{"sr:BAD"})
+		convey.So(report.Evicted, convey.ShouldResemble, []string{"sr:GONE"})
+		convey.So(provider.HasKey("sr:BAD"), convey.ShouldBeTrue)
+	})
+	convey.Convey("item written without SyncKind is committed", t, func() {
+		committed := map[string]interface{}{}
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			committed[key] = value
+			return nil
+		}
+		provider := kvsimple.New()
+		kv, e := kiva.NewCtx(context.Background(), provider, nil, nil, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute},
+		})
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(kv.Set("sr:PLAIN", 3, nil, true), convey.ShouldBeNil)
+		convey.So(provider.ItemOpts("sr:PLAIN").Pending(), convey.ShouldBeTrue)
+
+		report, e := kv.SyncNow(context.Background())
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(report.Committed, convey.ShouldResemble, []string{"sr:PLAIN"})
+		convey.So(committed["sr:PLAIN"], convey.ShouldEqual, 3)
+	})
+}
+
+// unreadableProvider fails reads of key as a provider which is not reachable or holds value not decodable
+type unreadableProvider struct {
+	kiva.Provider
+	key string
+}
+
+func (p *unreadableProvider) Get(key string, dest interface{}) (*kiva.ItemOptions, error) {
+	if key == p.key {
+		return nil, errors.New("connection refused")
+	}
+	return p.Provider.Get(key, dest)
+}
@@ -0,0 +1,102 @@
+package kiva
+
+import (
+	"strings"
+)
+
+// Table is a typed view of one table of Kiva, all its items are of type T.
+// Creating a Table registers T as item type of the table, so Sync reads its items as T
+// instead of using ItemReflectorFunc given on New
+type Table[T any] struct {
+	kv   *Kiva
+	name string
+}
+
+func NewTable[T any](kv *Kiva, name string) *Table[T] {
+	kv.RegisterTable(name, func(string) interface{} {
+		return new(T)
+	})
+	return &Table[T]{kv: kv, name: name}
+}
+
+func (t *Table[T]) Name() string {
+	return t.name
+}
+
+// Key returns kiva key of given id, i.e. table:id
+func (t *Table[T]) Key(id string) string {
+	return t.name + ":" + id
+}
+
+func (t *Table[T]) Get(id string) (T, error) {
+	var item T
+	if e := t.kv.Get(t.Key(id), &item); e != nil {
+		return item, e
+	}
+	return item, nil
+}
+
+// Set writes item using default write options and sync it to persistent storage when default SyncKind is SyncNow
+func (t *Table[T]) Set(id string, item T) error {
+	return t.kv.Set(t.Key(id), item, nil, true)
+}
+
+func (t *Table[T]) SetWithOptions(id string, item T, opts *WriteOptions, syncToDB bool) error {
+	return t.kv.Set(t.Key(id), item, opts, syncToDB)
+}
+
+// GetByPattern reads items of which id matches pattern, pattern is relative to table e.g. Data_*
+func (t *Table[T]) GetByPattern(pattern string, runGetterIfEmpty bool) ([]T, error) {
+	items := []T{}
+	if e := t.kv.GetByPattern(t.Key(pattern), &items, runGetterIfEmpty); e != nil {
+		return nil, e
+	}
+	return items, nil
+}
+
+func (t *Table[T]) GetRange(fromID, toID string, runGetterIfEmpty bool) ([]T, error) {
+	items := []T{}
+	if e := t.kv.GetRange(t.Key(fromID), t.Key(toID), &items, runGetterIfEmpty); e != nil {
+		return nil, e
+	}
+	return items, nil
+}
+
+// GetMany reads items of given ids, result is keyed by id and ids not found are left out
+func (t *Table[T]) GetMany(ids ...string) (map[string]T, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = t.Key(id)
+	}
+	items := map[string]T{}
+	e := t.kv.GetMany(keys, &items)
+
+	res := make(map[string]T, len(items))
+	prefix := t.name + ":"
+	for key, item := range items {
+		res[strings.TrimPrefix(key, prefix)] = item
+	}
+	return res, e
+}
+
+func (t *Table[T]) Delete(syncToDB bool, ids ...string) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = t.Key(id)
+	}
+	t.kv.Delete(syncToDB, keys...)
+}
+
+// IDs returns ids of the table matching pattern, pattern is relative to table
+func (t *Table[T]) IDs(pattern string) []string {
+	prefix := t.name + ":"
+	keys := t.kv.Keys(prefix + pattern)
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		ids = append(ids, strings.TrimPrefix(key, prefix))
+	}
+	return ids
+}
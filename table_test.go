@@ -0,0 +1,59 @@
+package kiva_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestTable(t *testing.T) {
+	tableName = "datatyped"
+	sourceStorage[tableName] = storage{}
+	convey.Convey("typed table", t, func() {
+		kv, e := prepareKiva()
+		convey.So(e, convey.ShouldBeNil)
+		tbl := kiva.NewTable[allTypes](kv, tableName)
+
+		for i := 0; i < 20; i++ {
+			e = tbl.Set(fmt.Sprintf("T_%02d", i), allTypes{ID: fmt.Sprintf("T_%02d", i), Name: fmt.Sprintf("Name %d", i), Age: i, Created: time.Now()})
+			if e != nil {
+				break
+			}
+		}
+		convey.So(e, convey.ShouldBeNil)
+
+		convey.Convey("get", func() {
+			item, e := tbl.Get("T_05")
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(item.Name, convey.ShouldEqual, "Name 5")
+			convey.So(item.Age, convey.ShouldEqual, 5)
+		})
+
+		convey.Convey("read thru getter", func() {
+			sourceStorage[tableName]["DB_01"] = map[string]interface{}{"_id": "DB_01", "Value": allTypes{ID: "DB_01", Name: "From DB"}}
+			item, e := tbl.Get("DB_01")
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(item.Name, convey.ShouldEqual, "From DB")
+		})
+
+		convey.Convey("pattern and range", func() {
+			items, e := tbl.GetByPattern("T_1*", false)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(len(items), convey.ShouldEqual, 10)
+			convey.So(items[0].Name, convey.ShouldEqual, "Name 10")
+
+			items, e = tbl.GetRange("T_03", "T_06", false)
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(len(items), convey.ShouldEqual, 4)
+			convey.So(tbl.IDs("T_0*")[9], convey.ShouldEqual, "T_09")
+		})
+
+		convey.Convey("delete", func() {
+			tbl.Delete(false, "T_01", "T_02")
+			convey.So(len(tbl.IDs("*")), convey.ShouldEqual, 18)
+		})
+	})
+}
@@ -0,0 +1,153 @@
+package kiva
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+const minMarkersPrune = 1024
+
+// markers remembers keys which may hold marker items, listing keys reads options of those keys only.
+// Each key has sequence of its latest add, so a key found to be regular is not dropped when a marker
+// has been written again meanwhile
+type markers struct {
+	mtx       sync.Mutex
+	keys      map[string]uint64
+	seq       uint64
+	pruneSize int
+	scanOnce  sync.Once
+}
+
+func newMarkers() *markers {
+	return &markers{keys: make(map[string]uint64), pruneSize: minMarkersPrune}
+}
+
+// add records key as marker, it returns true when set has grown enough to be pruned
+func (m *markers) add(key string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.seq++
+	m.keys[key] = m.seq
+	return len(m.keys) >= m.pruneSize
+}
+
+func (m *markers) get(key string) (uint64, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	seq, ok := m.keys[key]
+	return seq, ok
+}
+
+// removeIf drops key unless it has been added again after seq
+func (m *markers) removeIf(key string, seq uint64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.keys[key] == seq {
+		delete(m.keys, key)
+	}
+}
+
+// seen records state of key found on scan
+func (m *markers) seen(key string, opts *ItemOptions) {
+	if opts != nil && opts.Hidden() {
+		m.add(key)
+		return
+	}
+	if seq, ok := m.get(key); ok {
+		m.removeIf(key, seq)
+	}
+}
+
+func (m *markers) snapshot() map[string]uint64 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	res := make(map[string]uint64, len(m.keys))
+	for key, seq := range m.keys {
+		res[key] = seq
+	}
+	return res
+}
+
+func (m *markers) pruned() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.pruneSize = 2 * len(m.keys)
+	if m.pruneSize < minMarkersPrune {
+		m.pruneSize = minMarkersPrune
+	}
+}
+
+// addMarker records key of marker item written by Kiva, markers expired or removed by provider are pruned
+// once the set has doubled
+func (k *Kiva) addMarker(key string) {
+	if !k.markers.add(key) {
+		return
+	}
+	for key, seq := range k.markers.snapshot() {
+		if opts := k.provider.ItemOpts(key); opts == nil || !opts.Hidden() {
+			k.markers.removeIf(key, seq)
+		}
+	}
+	k.markers.pruned()
+}
+
+// deleteDeferred replaces item by a tombstone which is committed as delete by Sync, in order with saves of
+// the same key. It returns false when item is not on provider, its value is needed as tombstone value
+// so reads decoding into their usual dest keep working
+func (k *Kiva) deleteDeferred(ctx context.Context, key string) bool {
+	tableName, _, _ := ParseKey(key)
+	item := k.newItem(tableName)
+	if _, e := ProviderGet(ctx, k.provider, key, item); e != nil {
+		return false
+	}
+	value := reflect.Indirect(reflect.ValueOf(item)).Interface()
+	if value == nil {
+		return false
+	}
+
+	// tombstone does not expire, it lives until it is committed
+	opts := &WriteOptions{SyncKind: SyncBatch, Kind: ItemTombstone}
+	if ProviderSet(ctx, k.provider, key, value, opts) != nil {
+		return false
+	}
+	k.addMarker(key)
+	return true
+}
+
+// deferDelete tells whether delete of key should wait for Sync, it follows SyncKind of the item
+// or of its write policy when item is not on provider
+func (k *Kiva) deferDelete(key string) bool {
+	if k.SyncOptions().EveryInSecond <= 0 {
+		return false
+	}
+	if opts := k.provider.ItemOpts(key); opts != nil {
+		return opts.SyncKind == SyncBatch
+	}
+	return k.WriteOptionsOf(key).SyncKind == SyncBatch
+}
+
+// visibleKeys drops keys of marker items, only options of keys known as markers are read. Markers already
+// on provider are looked up once by scanning all keys
+func (k *Kiva) visibleKeys(ctx context.Context, keys []string) []string {
+	k.markers.scanOnce.Do(func() {
+		for _, key := range ProviderKeys(ctx, k.provider, "*") {
+			k.markers.seen(key, k.provider.ItemOpts(key))
+		}
+	})
+
+	res := make([]string, 0, len(keys))
+	for _, key := range keys {
+		seq, suspect := k.markers.get(key)
+		if !suspect {
+			res = append(res, key)
+			continue
+		}
+		if opts := k.provider.ItemOpts(key); opts != nil && opts.Hidden() {
+			continue
+		}
+		k.markers.removeIf(key, seq)
+		res = append(res, key)
+	}
+	return res
+}
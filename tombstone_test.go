@@ -0,0 +1,120 @@
+package kiva_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeferredDelete(t *testing.T) {
+	convey.Convey("delete on batch sync", t, func() {
+		mtx := new(sync.Mutex)
+		ops := []string{}
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			ops = append(ops, string(op)+" "+key)
+			return nil
+		}
+		getterCalls := 0
+		getter := func(ctx context.Context, key1, key2 string, op kiva.GetKind, dest interface{}) error {
+			getterCalls++
+			*(dest.(*int)) = 1
+			return nil
+		}
+		committedOps := func() []string {
+			mtx.Lock()
+			defer mtx.Unlock()
+			return append([]string{}, ops...)
+		}
+
+		provider := kvsimple.New()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		kv, e := kiva.NewCtx(ctx, provider, func(string) interface{} { return 0 }, getter, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+			SyncBatch:    kiva.SyncBatchOptions{EveryInSecond: 1},
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		kv.Set("tomb:A", 10, nil, true)
+		kv.Set("tomb:B", 20, nil, true)
+		kv.Delete(true, "tomb:A")
+
+		convey.Convey("tombstone hides key until committed", func() {
+			convey.So(committedOps(), convey.ShouldBeEmpty)
+			v := 0
+			convey.So(kv.Get("tomb:A", &v), convey.ShouldEqual, kiva.ErrNotFound)
+			convey.So(getterCalls, convey.ShouldEqual, 0)
+			convey.So(kv.Keys("tomb:*"), convey.ShouldResemble, []string{"tomb:B"})
+
+			items := []int{}
+			convey.So(kv.GetByPattern("tomb:*", &items, false), convey.ShouldBeNil)
+			convey.So(items, convey.ShouldResemble, []int{20})
+
+			time.Sleep(1500 * time.Millisecond)
+			convey.So(committedOps(), convey.ShouldResemble, []string{"delete tomb:A", "save tomb:B"})
+			convey.So(provider.HasKey("tomb:A"), convey.ShouldBeFalse)
+		})
+
+		convey.Convey("set after delete replaces tombstone", func() {
+			kv.Set("tomb:A", 30, nil, true)
+			v := 0
+			convey.So(kv.Get("tomb:A", &v), convey.ShouldBeNil)
+			convey.So(v, convey.ShouldEqual, 30)
+
+			time.Sleep(1500 * time.Millisecond)
+			convey.So(committedOps(), convey.ShouldResemble, []string{"save tomb:A", "save tomb:B"})
+		})
+
+		convey.Convey("key not on cache is deleted immediately", func() {
+			kv.Delete(true, "tomb:C")
+			convey.So(committedOps(), convey.ShouldResemble, []string{"delete tomb:C"})
+		})
+	})
+}
+
+func TestVisibleKeys(t *testing.T) {
+	convey.Convey("listing keys reads options of markers only", t, func() {
+		provider := &countingProvider{Provider: kvsimple.New()}
+		for i := 0; i < 10; i++ {
+			provider.Set(fmt.Sprintf("vis:%d", i), i, &kiva.WriteOptions{TTL: time.Minute})
+		}
+		provider.Set("vis:M", 0, &kiva.WriteOptions{TTL: time.Minute, Kind: kiva.ItemNegative})
+		getter := func(ctx context.Context, key1, key2 string, op kiva.GetKind, dest interface{}) error {
+			return io.EOF
+		}
+		kv, e := kiva.NewCtx(context.Background(), provider, func(string) interface{} { return 0 }, getter, nil, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute},
+			NegativeTTL:  time.Minute,
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		// markers already on provider are found by a single scan
+		convey.So(len(kv.Keys("vis:*")), convey.ShouldEqual, 10)
+		v := 0
+		convey.So(kv.Get("vis:N", &v), convey.ShouldEqual, kiva.ErrNotFound)
+
+		atomic.StoreInt64(&provider.itemOpts, 0)
+		keys := kv.Keys("vis:*")
+		convey.So(len(keys), convey.ShouldEqual, 10)
+		convey.So(keys, convey.ShouldNotContain, "vis:M")
+		convey.So(keys, convey.ShouldNotContain, "vis:N")
+		convey.So(atomic.LoadInt64(&provider.itemOpts), convey.ShouldEqual, 2)
+
+		res := []int{}
+		convey.So(kv.GetByPattern("vis:*", &res, false), convey.ShouldBeNil)
+		convey.So(len(res), convey.ShouldEqual, 10)
+
+		convey.So(kv.Set("vis:M", 99, nil, false), convey.ShouldBeNil)
+		convey.So(len(kv.Keys("vis:*")), convey.ShouldEqual, 11)
+	})
+}
@@ -0,0 +1,103 @@
+package kiva
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const keyLockStripes = 256
+
+// syncTask is a unit of sync work, tasks of the same table share the table concurrency limit
+type syncTask struct {
+	tableName string
+	run       func(ctx context.Context)
+}
+
+// runTasks runs tasks on SyncBatch.Workers goroutines and waits for all of them. Number of tasks of a table
+// running at a time is bounded by TableConcurrency, and every task gets SyncTimeoutInSecond as its deadline
+func (k *Kiva) runTasks(ctx context.Context, tasks []syncTask) {
+	if len(tasks) == 0 {
+		return
+	}
+	batchOpts := k.SyncOptions()
+	workers := batchOpts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(tasks) {
+		workers = len(tasks)
+	}
+
+	tableSlots := map[string]chan struct{}{}
+	for _, t := range tasks {
+		if _, ok := tableSlots[t.tableName]; ok {
+			continue
+		}
+		limit := batchOpts.MaxPerTable
+		if n, ok := batchOpts.TableConcurrency[t.tableName]; ok {
+			limit = n
+		}
+		if limit > 0 && limit < workers {
+			tableSlots[t.tableName] = make(chan struct{}, limit)
+		} else {
+			tableSlots[t.tableName] = nil
+		}
+	}
+
+	run := func(t syncTask) {
+		if slots := tableSlots[t.tableName]; slots != nil {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+		}
+		taskCtx, cancel := ctx, context.CancelFunc(func() {})
+		if batchOpts.SyncTimeoutInSecond > 0 {
+			taskCtx, cancel = context.WithTimeout(ctx, time.Duration(batchOpts.SyncTimeoutInSecond)*time.Second)
+		}
+		defer cancel()
+		t.run(taskCtx)
+	}
+
+	if workers == 1 {
+		for _, t := range tasks {
+			if ctx.Err() != nil {
+				return
+			}
+			run(t)
+		}
+		return
+	}
+
+	queue := make(chan syncTask)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				run(t)
+			}
+		}()
+	}
+	for _, t := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- t
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// keyLocks serializes sync work of the same key, e.g. commit by sync worker and commit of a SyncNow write,
+// keys are spread over fixed number of mutexes
+type keyLocks [keyLockStripes]sync.Mutex
+
+func (l *keyLocks) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	m := &l[h.Sum32()%keyLockStripes]
+	m.Lock()
+	return m.Unlock
+}
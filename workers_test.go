@@ -0,0 +1,84 @@
+package kiva_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestSyncWorkers(t *testing.T) {
+	convey.Convey("parallel sync", t, func() {
+		mtx := new(sync.Mutex)
+		running := map[string]int{}
+		maxRunning := map[string]int{}
+		committed := 0
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			tableName, _, _ := kiva.ParseKey(key)
+			mtx.Lock()
+			running[tableName]++
+			running["*"]++
+			for _, name := range []string{tableName, "*"} {
+				if running[name] > maxRunning[name] {
+					maxRunning[name] = running[name]
+				}
+			}
+			mtx.Unlock()
+
+			var e error
+			if tableName == "stuck" {
+				<-ctx.Done()
+				e = ctx.Err()
+			} else {
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			mtx.Lock()
+			running[tableName]--
+			running["*"]--
+			if e == nil {
+				committed++
+			}
+			mtx.Unlock()
+			return e
+		}
+
+		provider := kvsimple.New()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		kv, e := kiva.NewCtx(ctx, provider, func(string) interface{} { return 0 }, nil, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Hour, SyncKind: kiva.SyncBatch},
+			SyncBatch: kiva.SyncBatchOptions{
+				EveryInSecond:       1,
+				SyncTimeoutInSecond: 1,
+				Workers:             4,
+				TableConcurrency:    map[string]int{"serial": 1},
+				RetryBackoff:        time.Hour,
+			},
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		for i := 0; i < 6; i++ {
+			kv.Set(fmt.Sprintf("parallel:%d", i), i, nil, false)
+			kv.Set(fmt.Sprintf("serial:%d", i), i, nil, false)
+		}
+		kv.Set("stuck:1", 1, nil, false)
+		time.Sleep(2500 * time.Millisecond)
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		convey.So(committed, convey.ShouldEqual, 12)
+		convey.So(maxRunning["*"], convey.ShouldEqual, 4)
+		convey.So(maxRunning["parallel"], convey.ShouldBeGreaterThan, 1)
+		convey.So(maxRunning["serial"], convey.ShouldEqual, 1)
+
+		stuck := provider.ItemOpts("stuck:1")
+		convey.So(stuck.SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+		convey.So(stuck.LastCommitError, convey.ShouldEqual, context.DeadlineExceeded.Error())
+	})
+}
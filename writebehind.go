@@ -0,0 +1,225 @@
+package kiva
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultMaxRetryBackoff = 5 * time.Minute
+)
+
+// FailedCommit is an item of which commit has failed MaxAttempts times
+type FailedCommit struct {
+	Key       string
+	Value     interface{}
+	Op        CommitKind
+	Attempts  int
+	LastError string
+	FailedAt  time.Time
+}
+
+// DeadLetterStore keeps failed commits to be inspected and replayed, there is at most one entry per key.
+// Put of a key already in store replaces its entry
+type DeadLetterStore interface {
+	Put(fc FailedCommit) error
+	List() ([]FailedCommit, error)
+	Remove(key string) error
+}
+
+type memoryDeadLetter struct {
+	mtx     sync.RWMutex
+	commits map[string]FailedCommit
+}
+
+// NewMemoryDeadLetter returns DeadLetterStore on process memory, it is used when KivaOptions.DeadLetter is not set
+func NewMemoryDeadLetter() DeadLetterStore {
+	return &memoryDeadLetter{commits: make(map[string]FailedCommit)}
+}
+
+func (s *memoryDeadLetter) Put(fc FailedCommit) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.commits[fc.Key] = fc
+	return nil
+}
+
+func (s *memoryDeadLetter) List() ([]FailedCommit, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	res := make([]FailedCommit, 0, len(s.commits))
+	for _, fc := range s.commits {
+		res = append(res, fc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res, nil
+}
+
+func (s *memoryDeadLetter) Remove(key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.commits, key)
+	return nil
+}
+
+// commit runs committer for item waiting on provider, on success item is marked as synced
+// otherwise its next attempt is scheduled or it is moved to dead letter. Caller holds lock of key
+func (k *Kiva) commit(ctx context.Context, key string, value interface{}, op CommitKind, opts *ItemOptions) error {
+	if e := k.commitOne(ctx, key, value, op); e != nil {
+		k.commitFailed(key, value, op, opts, e)
+		return e
+	}
+	k.committed(key, op, opts)
+	return nil
+}
+
+// unchanged tells whether item still holds the write of which options are given,
+// item written again while its older value is being committed stays dirty for its own commit
+func (k *Kiva) unchanged(key string, opts *ItemOptions) bool {
+	current := k.provider.ItemOpts(key)
+	return current != nil && current.Version == opts.Version
+}
+
+func (k *Kiva) committed(key string, op CommitKind, opts *ItemOptions) {
+	// newer value has reached persistent storage, older failed one should not be replayed
+	k.deadLetter.Remove(key)
+	if !k.unchanged(key, opts) {
+		return
+	}
+	if op == CommitDelete {
+		ProviderDelete(k.ctx, k.provider, key)
+		return
+	}
+	k.provider.UpdateLastSyncTime(key)
+}
+
+func (k *Kiva) commitFailed(key string, value interface{}, op CommitKind, opts *ItemOptions, err error) {
+	atomic.AddInt64(&k.stats.commitFailures, 1)
+	if !k.unchanged(key, opts) {
+		// failed value is superseded, newer write is committed on its own
+		return
+	}
+	opts.CommitAttempts++
+	opts.LastCommitError = err.Error()
+
+	maxAttempts := k.SyncOptions().MaxAttempts
+	if maxAttempts > 0 && opts.CommitAttempts >= maxAttempts {
+		fc := FailedCommit{
+			Key:       key,
+			Value:     value,
+			Op:        op,
+			Attempts:  opts.CommitAttempts,
+			LastError: opts.LastCommitError,
+			FailedAt:  time.Now(),
+		}
+		if e := k.deadLetter.Put(fc); e == nil {
+			atomic.AddInt64(&k.stats.deadLetters, 1)
+			opts.SyncDirection = SyncDeadLetter
+			opts.NextCommit = time.Time{}
+			k.provider.ChangeSyncOpts(key, opts)
+			return
+		}
+	}
+
+	opts.NextCommit = time.Now().Add(k.retryBackoff(opts.CommitAttempts))
+	k.provider.ChangeSyncOpts(key, opts)
+}
+
+// retryBackoff is exponential delay of given attempt with jitter, the delay is randomized within its upper half
+func (k *Kiva) retryBackoff(attempt int) time.Duration {
+	batchOpts := k.SyncOptions()
+	base := batchOpts.RetryBackoff
+	if base <= 0 {
+		base = defaultRetryBackoff
+	}
+	max := batchOpts.MaxRetryBackoff
+	if max <= 0 {
+		max = defaultMaxRetryBackoff
+	}
+
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// FailedCommits lists commits which have been moved to dead letter store
+func (k *Kiva) FailedCommits() ([]FailedCommit, error) {
+	return k.deadLetter.List()
+}
+
+// ReplayDeadLetters commits again all items of dead letter store, replayed items are removed from the store.
+// Items failing again stay on the store with increased attempts
+func (k *Kiva) ReplayDeadLetters(ctx context.Context) (int, error) {
+	if !k.hasCommitter() {
+		return 0, fmt.Errorf("kv committer: invalid committer")
+	}
+	commits, e := k.deadLetter.List()
+	if e != nil {
+		return 0, fmt.Errorf("dead letter: %s", e.Error())
+	}
+
+	replayed, failed := 0, 0
+	for _, fc := range commits {
+		if e = ctx.Err(); e != nil {
+			return replayed, e
+		}
+		value, opts := k.deadLettered(ctx, fc)
+		if e = k.commitOne(ctx, fc.Key, value, fc.Op); e != nil {
+			failed++
+			fc.Attempts++
+			fc.LastError = e.Error()
+			fc.FailedAt = time.Now()
+			k.deadLetter.Put(fc)
+			continue
+		}
+		replayed++
+		k.replayed(fc, opts)
+	}
+	if failed > 0 {
+		return replayed, fmt.Errorf("%d commits failed again", failed)
+	}
+	return replayed, nil
+}
+
+// deadLettered returns value to replay, item still dead lettered on provider is replayed with its value
+// there which keeps its type when store has decoded the failed value generically. Options are nil when
+// item has been removed or written again
+func (k *Kiva) deadLettered(ctx context.Context, fc FailedCommit) (interface{}, *ItemOptions) {
+	tableName, _, _ := ParseKey(fc.Key)
+	item := k.newItem(tableName)
+	opts, e := ProviderGet(ctx, k.provider, fc.Key, item)
+	if e != nil || opts.SyncDirection != SyncDeadLetter {
+		return fc.Value, nil
+	}
+	if fc.Op == CommitDelete {
+		return nil, opts
+	}
+	return reflect.Indirect(reflect.ValueOf(item)).Interface(), opts
+}
+
+// replayed marks item as synced once its dead lettered value is committed, item written again meanwhile
+// is no longer dead lettered and waits for its own commit
+func (k *Kiva) replayed(fc FailedCommit, opts *ItemOptions) {
+	if opts == nil {
+		k.deadLetter.Remove(fc.Key)
+		return
+	}
+	unlock := k.keyLocks.lock(fc.Key)
+	defer unlock()
+	k.committed(fc.Key, fc.Op, opts)
+}
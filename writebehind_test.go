@@ -0,0 +1,133 @@
+package kiva_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sebarcode/kiva"
+	"github.com/sebarcode/kiva/kvsimple"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteBehind(t *testing.T) {
+	convey.Convey("committer keeps failing", t, func() {
+		var (
+			down  int32 = 1
+			calls int64
+		)
+		committed := make(chan string, 10)
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			atomic.AddInt64(&calls, 1)
+			if atomic.LoadInt32(&down) == 1 {
+				return errors.New("db is down")
+			}
+			committed <- key
+			return nil
+		}
+		provider := kvsimple.New()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		kv, e := kiva.NewCtx(ctx, provider, nil, nil, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+			SyncBatch: kiva.SyncBatchOptions{
+				EveryInSecond: 1,
+				MaxAttempts:   2,
+				RetryBackoff:  10 * time.Millisecond,
+			},
+		})
+		convey.So(e, convey.ShouldBeNil)
+
+		convey.Convey("failed commit on set is scheduled for retry", func() {
+			e := kv.Set("wb:Now", 1, &kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncNow}, true)
+			convey.So(e, convey.ShouldNotBeNil)
+			opts := provider.ItemOpts("wb:Now")
+			convey.So(opts.SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+			convey.So(opts.CommitAttempts, convey.ShouldEqual, 1)
+			convey.So(opts.LastCommitError, convey.ShouldEqual, "db is down")
+			convey.So(opts.NextCommit.After(time.Now()), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("item is moved to dead letter after max attempts", func() {
+			convey.So(kv.Set("wb:A", 10, nil, true), convey.ShouldBeNil)
+			for i := 0; i < 40 && kv.Stats().DeadLetters == 0; i++ {
+				time.Sleep(100 * time.Millisecond)
+			}
+			convey.So(kv.Stats().DeadLetters, convey.ShouldEqual, 1)
+			convey.So(kv.Stats().CommitFailures, convey.ShouldEqual, 2)
+			convey.So(provider.ItemOpts("wb:A").SyncDirection, convey.ShouldEqual, kiva.SyncDeadLetter)
+
+			failed, e := kv.FailedCommits()
+			convey.So(e, convey.ShouldBeNil)
+			convey.So(len(failed), convey.ShouldEqual, 1)
+			convey.So(failed[0].Key, convey.ShouldEqual, "wb:A")
+			convey.So(failed[0].Value, convey.ShouldEqual, 10)
+			convey.So(failed[0].Attempts, convey.ShouldEqual, 2)
+
+			convey.Convey("replay commits dead letters", func() {
+				n, e := kv.ReplayDeadLetters(context.Background())
+				convey.So(e, convey.ShouldNotBeNil)
+				convey.So(n, convey.ShouldEqual, 0)
+
+				atomic.StoreInt32(&down, 0)
+				n, e = kv.ReplayDeadLetters(context.Background())
+				convey.So(e, convey.ShouldBeNil)
+				convey.So(n, convey.ShouldEqual, 1)
+				convey.So(<-committed, convey.ShouldEqual, "wb:A")
+				convey.So(provider.ItemOpts("wb:A").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+
+				failed, _ = kv.FailedCommits()
+				convey.So(failed, convey.ShouldBeEmpty)
+			})
+		})
+	})
+	convey.Convey("write arriving while item is committed", t, func() {
+		started, release := make(chan struct{}, 1), make(chan struct{})
+		mtx := new(sync.Mutex)
+		db := map[string]interface{}{}
+		committer := func(ctx context.Context, key string, value interface{}, op kiva.CommitKind) error {
+			select {
+			case started <- struct{}{}:
+				<-release
+			default:
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			db[key] = value
+			return nil
+		}
+		provider := kvsimple.New()
+		kv, e := kiva.NewCtx(context.Background(), provider, nil, nil, committer, &kiva.KivaOptions{
+			DefaultWrite: kiva.WriteOptions{TTL: time.Minute, SyncKind: kiva.SyncBatch},
+		})
+		convey.So(e, convey.ShouldBeNil)
+		convey.So(kv.Set("wr:A", 1, nil, true), convey.ShouldBeNil)
+
+		synced := make(chan struct{})
+		go func() {
+			kv.SyncKeys(context.Background(), "wr:A")
+			close(synced)
+		}()
+		<-started
+		written := make(chan struct{})
+		go func() {
+			kv.Set("wr:A", 2, nil, true)
+			close(written)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		<-synced
+		<-written
+
+		convey.So(provider.ItemOpts("wr:A").SyncDirection, convey.ShouldEqual, kiva.SyncToPersistent)
+		_, e = kv.SyncKeys(context.Background(), "wr:A")
+		convey.So(e, convey.ShouldBeNil)
+		mtx.Lock()
+		convey.So(db["wr:A"], convey.ShouldEqual, 2)
+		mtx.Unlock()
+		convey.So(provider.ItemOpts("wr:A").SyncDirection, convey.ShouldEqual, kiva.SyncToHots)
+	})
+}